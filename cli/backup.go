@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+)
+
+var backupCommand = &command{
+	name:    "backup",
+	summary: "Dump the database with mydumper and upload it as a new backup",
+	setFlags: func(fs *flag.FlagSet) func(context.Context, *pkg.StorageFlags) error {
+		var (
+			opts         pkg.BackupOptions
+			mydumperArgs string
+		)
+		fs.StringVar(&opts.Dump.Path, "mydumper", "/mydumper", "Path of the mydumper binary")
+		fs.StringVar(&opts.Dump.Host, "host", "127.0.0.1", "Host of the TiDB server")
+		fs.IntVar(&opts.Dump.Port, "port", 4000, "Port of the TiDB server")
+		fs.StringVar(&opts.Dump.User, "user", "root", "User connecting to the TiDB server")
+		fs.StringVar(&opts.Dump.Password, "password", "", "Password of the user")
+		fs.StringVar(&mydumperArgs, "mydumper-args", "", "Space separated extra arguments passed to mydumper")
+		fs.StringVar(&opts.WorkDir, "work-dir", os.TempDir(), "Local directory the dump is written to before being uploaded")
+		fs.StringVar(&opts.NamePrefix, "name-prefix", pkg.DefaultBackupNamePrefix, "Prefix of the backup name, followed by the backup start time and a random suffix")
+		uf := registerUploadFlags(fs)
+
+		return func(ctx context.Context, storage *pkg.StorageFlags) error {
+			opts.Dump.Args = strings.Fields(mydumperArgs)
+			var err error
+			if opts.Upload, err = uf.options(); err != nil {
+				return err
+			}
+			b, err := openBucket(ctx, storage)
+			if err != nil {
+				return err
+			}
+
+			manifest, err := pkg.Backup(ctx, b, opts)
+			if err != nil {
+				return err
+			}
+			log.Printf("Backup %s finished: %d files (%d bytes)", manifest.Name, len(manifest.Objects), manifest.TotalSize())
+			return nil
+		}
+	},
+}
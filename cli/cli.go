@@ -0,0 +1,181 @@
+// Package cli implements the commands of the tidb-cloud-backup binary and of
+// the uploader and downloader binaries kept for compatibility.
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+	"gocloud.dev/blob"
+)
+
+// Exit codes of the commands.
+const (
+	// ExitOK is returned when the command succeeded.
+	ExitOK = 0
+	// ExitFailure is returned when the command failed for any other reason.
+	ExitFailure = 1
+	// ExitUsage is returned for invalid commands, flags or arguments.
+	ExitUsage = 2
+	// ExitStorage is returned when the bucket cannot be opened.
+	ExitStorage = 3
+	// ExitIncomplete is returned when a backup is incomplete or fails
+	// verification.
+	ExitIncomplete = 4
+	// ExitTransfer is returned when some files failed to be transferred.
+	ExitTransfer = 5
+)
+
+// Program is the name of the tidb-cloud-backup binary.
+const Program = "tidb-cloud-backup"
+
+// command is a subcommand of the tidb-cloud-backup binary.
+type command struct {
+	name    string
+	summary string
+	// setFlags defines the flags of the command on fs and returns the
+	// function running it once they are parsed. The function validates its
+	// flags before opening the bucket with openBucket.
+	setFlags func(fs *flag.FlagSet) func(ctx context.Context, storage *pkg.StorageFlags) error
+}
+
+var commands = []*command{
+	backupCommand,
+	uploadCommand,
+	downloadCommand,
+	restoreCommand,
+	listCommand,
+	verifyCommand,
+	deleteCommand,
+	pruneCommand,
+}
+
+// usageError reports invalid flags or arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// storageError reports a bucket that cannot be opened.
+type storageError struct {
+	err error
+}
+
+func (e *storageError) Error() string {
+	return e.err.Error()
+}
+
+// openBucket opens the bucket selected by the storage flags. An unknown cloud
+// provider is a usage error rather than a storage one.
+func openBucket(ctx context.Context, storage *pkg.StorageFlags) (*blob.Bucket, error) {
+	if !pkg.ValidCloudProvider(storage.Cloud) {
+		return nil, usageError(fmt.Sprintf("--cloud must be one of %s", strings.Join(pkg.CloudProviders, ", ")))
+	}
+	b, err := storage.Setup(ctx)
+	if err != nil {
+		return nil, &storageError{err}
+	}
+	return b, nil
+}
+
+// Main runs the command named by the first of args and returns the exit code.
+func Main(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return ExitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return ExitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(Program+" "+cmd.name, args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", Program, args[0])
+	usage(os.Stderr)
+	return ExitUsage
+}
+
+// RunCommand runs the command name as the program binary, for binaries
+// running a single command, and returns the exit code.
+func RunCommand(program, name string, args []string) int {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(program, args)
+		}
+	}
+	panic("unknown command " + name)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", Program)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", Program)
+}
+
+func (c *command) run(program string, args []string) int {
+	fs := flag.NewFlagSet(program, flag.ContinueOnError)
+	storage := pkg.RegisterStorageFlags(fs)
+	run := c.setFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\n%s.\n\nFlags:\n", program, c.summary)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "%s: unexpected arguments: %v\n", program, fs.Args())
+		fs.Usage()
+		return ExitUsage
+	}
+
+	err := run(context.Background(), storage)
+	switch err.(type) {
+	case nil:
+		return ExitOK
+	case usageError:
+		fmt.Fprintf(fs.Output(), "%s: %v\n", program, err)
+		fs.Usage()
+		return ExitUsage
+	case *storageError:
+		log.Printf("Failed to setup bucket: %s", err)
+		return ExitStorage
+	}
+	log.Printf("Failed to %s: %v", c.name, err)
+	return exitCode(err)
+}
+
+// exitCode returns the exit code of a command failing with err. Transfers
+// failing because an object did not match its checksum are reported as
+// failing verification rather than as transfer errors.
+func exitCode(err error) int {
+	switch err := err.(type) {
+	case *pkg.IncompleteError, *pkg.ChecksumError, *pkg.VerifyError:
+		return ExitIncomplete
+	case pkg.TransferErrors:
+		for _, fe := range err {
+			if _, ok := fe.Err.(*pkg.ChecksumError); ok {
+				return ExitIncomplete
+			}
+		}
+		return ExitTransfer
+	default:
+		return ExitFailure
+	}
+}
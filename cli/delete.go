@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+)
+
+var deleteCommand = &command{
+	name:    "delete",
+	summary: "Delete a backup from the bucket",
+	setFlags: func(fs *flag.FlagSet) func(context.Context, *pkg.StorageFlags) error {
+		var backup string
+		fs.StringVar(&backup, "backup", "", "Name of the backup in the bucket")
+
+		return func(ctx context.Context, storage *pkg.StorageFlags) error {
+			if backup == "" {
+				return usageError("--backup is required")
+			}
+			b, err := openBucket(ctx, storage)
+			if err != nil {
+				return err
+			}
+
+			// Deleting a mistyped name would otherwise succeed silently.
+			exists, err := pkg.BackupExists(ctx, b, backup)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("backup %s not found in the bucket", backup)
+			}
+			if err = pkg.DeleteBackup(ctx, b, backup); err != nil {
+				return err
+			}
+			log.Printf("Delete backup: %s successfully", backup)
+			return nil
+		}
+	},
+}
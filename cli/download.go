@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+)
+
+var downloadCommand = &command{
+	name:    "download",
+	summary: "Download a backup from the bucket",
+	setFlags: func(fs *flag.FlagSet) func(context.Context, *pkg.StorageFlags) error {
+		var (
+			srcDir     string
+			destDir    string
+			incomplete bool
+		)
+		fs.StringVar(&srcDir, "srcDir", "", "Source data directory in bucket")
+		fs.StringVar(&destDir, "destDir", "", "Destination directory on local")
+		fs.BoolVar(&incomplete, "allow-incomplete", false, "Download the backup even if its manifest is missing or does not match the bucket, reporting objects failing their checksum without stopping (forensic use only)")
+		df := registerDownloadFlags(fs)
+
+		return func(ctx context.Context, storage *pkg.StorageFlags) error {
+			if srcDir == "" || destDir == "" {
+				return usageError("--srcDir and --destDir are required")
+			}
+			opts, err := df.options()
+			if err != nil {
+				return err
+			}
+			opts.AllowIncomplete = incomplete
+			b, err := openBucket(ctx, storage)
+			if err != nil {
+				return err
+			}
+
+			return pkg.NewDownloader(b, opts).Download(ctx, srcDir, destDir)
+		}
+	},
+}
+
+// downloadFlags holds the flags of the commands downloading backups.
+type downloadFlags struct {
+	concurrency int
+	retries     int
+	keyFiles    string
+	filter      *filterFlags
+}
+
+func registerDownloadFlags(fs *flag.FlagSet) *downloadFlags {
+	f := &downloadFlags{}
+	fs.IntVar(&f.concurrency, "concurrency", 4, "Number of files downloaded in parallel")
+	fs.IntVar(&f.retries, "retries", 2, "Number of times an object failing checksum verification is downloaded again")
+	fs.StringVar(&f.keyFiles, "encryption-key-file", "", "Comma separated files holding the keys used to decrypt objects, $"+pkg.EncryptionKeyEnv+" is also used if set")
+	f.filter = registerFilterFlags(fs, "download")
+	return f
+}
+
+// options returns the download options described by the flags.
+func (f *downloadFlags) options() (pkg.DownloadOptions, error) {
+	filter, err := f.filter.filter()
+	if err != nil {
+		return pkg.DownloadOptions{}, err
+	}
+	var paths []string
+	if f.keyFiles != "" {
+		paths = strings.Split(f.keyFiles, ",")
+	}
+	keyring, err := pkg.LoadKeyring(paths)
+	if err != nil {
+		return pkg.DownloadOptions{}, fmt.Errorf("failed to load encryption keys: %v", err)
+	}
+	return pkg.DownloadOptions{
+		Concurrency: f.concurrency,
+		Retries:     f.retries,
+		Keyring:     keyring,
+		Filter:      filter,
+	}, nil
+}
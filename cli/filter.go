@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+)
+
+// filterFlags holds the flags selecting the files of a backup transferred.
+type filterFlags struct {
+	include patternList
+	exclude patternList
+}
+
+func registerFilterFlags(fs *flag.FlagSet, verb string) *filterFlags {
+	f := &filterFlags{}
+	fs.Var(&f.include, "include", "Only "+verb+" the files matching these `patterns`, comma separated and repeatable: db or db.table globs over mydumper database and table names, or globs over keys relative to the backup when containing a slash, e.g. /metadata")
+	fs.Var(&f.exclude, "exclude", "Do not "+verb+" the files matching these `patterns`, in the same format as --include")
+	return f
+}
+
+// filter returns the filter described by the flags, nil if none is given.
+func (f *filterFlags) filter() (*pkg.Filter, error) {
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return nil, nil
+	}
+	filter, err := pkg.NewFilter(f.include, f.exclude)
+	if err != nil {
+		return nil, usageError(err.Error())
+	}
+	return filter, nil
+}
+
+// patternList is a repeatable flag holding comma separated patterns.
+type patternList []string
+
+func (l *patternList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *patternList) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+)
+
+var listCommand = &command{
+	name:    "list",
+	summary: "List the backups stored in the bucket",
+	setFlags: func(fs *flag.FlagSet) func(context.Context, *pkg.StorageFlags) error {
+		var format string
+		fs.StringVar(&format, "format", "table", "Output format: table or json")
+
+		return func(ctx context.Context, storage *pkg.StorageFlags) error {
+			if format != "table" && format != "json" {
+				return usageError("--format must be table or json")
+			}
+			b, err := openBucket(ctx, storage)
+			if err != nil {
+				return err
+			}
+
+			infos, err := pkg.DescribeBackups(ctx, b)
+			if err != nil {
+				return err
+			}
+			if format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(infos)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tTIME\tSIZE\tOBJECTS\tSTATUS\tBINLOG POSITION")
+			for _, info := range infos {
+				pos := "-"
+				if info.Dump != nil && info.Dump.BinlogPosition() != "" {
+					pos = info.Dump.BinlogPosition()
+				}
+				if info.Error != "" {
+					// The error has been logged by DescribeBackups.
+					fmt.Fprintf(w, "%s\t-\t-\t-\t%s\t%s\n", info.Name, info.Status, pos)
+					continue
+				}
+				status := info.Status
+				if info.Filter != nil {
+					status += " (filtered)"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", info.Name, info.Time.Format(time.RFC3339), formatSize(info.Size), info.Objects, status, pos)
+			}
+			return w.Flush()
+		}
+	},
+}
+
+// formatSize formats a size in bytes with a binary unit.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+)
+
+var pruneCommand = &command{
+	name:    "prune",
+	summary: "Delete the backups falling out of the retention policy",
+	setFlags: func(fs *flag.FlagSet) func(context.Context, *pkg.StorageFlags) error {
+		var opts pkg.PruneOptions
+		fs.IntVar(&opts.KeepLast, "keep-last", 0, "Number of most recent backups kept")
+		fs.DurationVar(&opts.KeepWithin, "keep-within", 0, "Keep the backups more recent than this duration, e.g. 72h")
+		fs.IntVar(&opts.KeepDaily, "keep-daily", 0, "Number of last days whose most recent backup is kept")
+		fs.IntVar(&opts.KeepWeekly, "keep-weekly", 0, "Number of last weeks whose most recent backup is kept")
+		fs.IntVar(&opts.KeepMonthly, "keep-monthly", 0, "Number of last months whose most recent backup is kept")
+		fs.BoolVar(&opts.DryRun, "dry-run", false, "Only print the backups that would be deleted")
+
+		return func(ctx context.Context, storage *pkg.StorageFlags) error {
+			if opts.KeepLast <= 0 && opts.KeepWithin <= 0 && opts.KeepDaily <= 0 && opts.KeepWeekly <= 0 && opts.KeepMonthly <= 0 {
+				return usageError("at least one of --keep-last, --keep-within, --keep-daily, --keep-weekly or --keep-monthly is required")
+			}
+			b, err := openBucket(ctx, storage)
+			if err != nil {
+				return err
+			}
+
+			deleted, err := pkg.Prune(ctx, b, opts)
+			if err != nil {
+				return err
+			}
+			if opts.DryRun {
+				log.Printf("Would prune %d backup(s)", len(deleted))
+			} else {
+				log.Printf("Pruned %d backup(s)", len(deleted))
+			}
+			return nil
+		}
+	},
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+)
+
+var restoreCommand = &command{
+	name:    "restore",
+	summary: "Download a backup, verify it and load it into TiDB with loader or tidb-lightning",
+	setFlags: func(fs *flag.FlagSet) func(context.Context, *pkg.StorageFlags) error {
+		var (
+			backup   string
+			opts     pkg.RestoreOptions
+			toolArgs string
+		)
+		fs.StringVar(&backup, "backup", "", "Name of the backup in the bucket")
+		fs.StringVar(&opts.Load.Tool, "tool", pkg.ToolLoader, "Tool loading the backup: loader or tidb-lightning")
+		fs.StringVar(&opts.Load.Path, "tool-path", "", "Path of the tool binary, defaults to /loader or /tidb-lightning")
+		fs.StringVar(&opts.Load.Host, "host", "127.0.0.1", "Host of the TiDB server")
+		fs.IntVar(&opts.Load.Port, "port", 4000, "Port of the TiDB server")
+		fs.StringVar(&opts.Load.User, "user", "root", "User connecting to the TiDB server")
+		fs.StringVar(&opts.Load.Password, "password", "", "Password of the user")
+		fs.StringVar(&toolArgs, "tool-args", "", "Space separated extra arguments passed to the tool")
+		fs.StringVar(&opts.WorkDir, "work-dir", os.TempDir(), "Local directory the backup is downloaded to before being loaded")
+		fs.BoolVar(&opts.KeepDownload, "keep-download", false, "Keep the downloaded backup once loaded, or when the restore fails so that running it again resumes the download")
+		df := registerDownloadFlags(fs)
+
+		return func(ctx context.Context, storage *pkg.StorageFlags) error {
+			if backup == "" {
+				return usageError("--backup is required")
+			}
+			switch opts.Load.Tool {
+			case pkg.ToolLoader, pkg.ToolLightning:
+			default:
+				return usageError("--tool must be loader or tidb-lightning")
+			}
+			if opts.Load.Path == "" {
+				opts.Load.Path = "/" + opts.Load.Tool
+			}
+			opts.Load.Args = strings.Fields(toolArgs)
+			var err error
+			if opts.Download, err = df.options(); err != nil {
+				return err
+			}
+			b, err := openBucket(ctx, storage)
+			if err != nil {
+				return err
+			}
+
+			if err = pkg.Restore(ctx, b, backup, opts); err != nil {
+				return err
+			}
+			log.Printf("Restore backup: %s successfully", backup)
+			if d, err := pkg.ReadManifestDump(ctx, b, backup); err == nil && d != nil && d.BinlogPosition() != "" {
+				log.Printf("Backup %s is consistent with binlog position %s", backup, d.BinlogPosition())
+			}
+			return nil
+		}
+	},
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+)
+
+var uploadCommand = &command{
+	name:    "upload",
+	summary: "Upload a backup directory to the bucket",
+	setFlags: func(fs *flag.FlagSet) func(context.Context, *pkg.StorageFlags) error {
+		var (
+			backupDir string
+			resume    bool
+		)
+		fs.StringVar(&backupDir, "backup-dir", "", "Backup directory")
+		fs.BoolVar(&resume, "resume", true, "Record the uploaded files in <backup-dir>.checkpoint, and skip them when the upload is run again after being interrupted")
+		uf := registerUploadFlags(fs)
+
+		return func(ctx context.Context, storage *pkg.StorageFlags) error {
+			if backupDir == "" {
+				return usageError("--backup-dir is required")
+			}
+			opts, err := uf.options()
+			if err != nil {
+				return err
+			}
+			if resume {
+				opts.CheckpointPath = pkg.CheckpointPath(backupDir)
+			}
+			b, err := openBucket(ctx, storage)
+			if err != nil {
+				return err
+			}
+
+			manifest, err := pkg.NewUploader(b, opts).UploadDir(ctx, backupDir, filepath.Base(backupDir))
+			if err != nil {
+				return err
+			}
+			log.Printf("Uploaded %d files (%d bytes), manifest written to %s", len(manifest.Objects), manifest.TotalSize(), pkg.ManifestKey(manifest.Name))
+			return nil
+		}
+	},
+}
+
+// uploadFlags holds the flags of the commands uploading backups.
+type uploadFlags struct {
+	bufferSize  int
+	memoryLimit int64
+	concurrency int
+	checksum    string
+	keyFile     string
+	compression string
+	filter      *filterFlags
+}
+
+func registerUploadFlags(fs *flag.FlagSet) *uploadFlags {
+	f := &uploadFlags{}
+	fs.IntVar(&f.bufferSize, "buffer-size", pkg.DefaultBufferSize, "Size in bytes of each part written to the bucket, raised for files too large to fit in 9000 parts")
+	fs.Int64Var(&f.memoryLimit, "memory-limit", 256<<20, "Maximum bytes buffered by all in-flight uploads, 0 for no limit")
+	fs.IntVar(&f.concurrency, "concurrency", 4, "Number of files uploaded in parallel")
+	fs.StringVar(&f.checksum, "checksum", pkg.DefaultChecksumAlgorithm, "Checksum algorithm recorded in the manifest: sha256 or crc32c")
+	fs.StringVar(&f.keyFile, "encryption-key-file", "", "File holding the AES-256 key used to encrypt objects, defaults to $"+pkg.EncryptionKeyEnv+" if set")
+	fs.StringVar(&f.compression, "compress", pkg.CompressionNone, "Compress files while uploading: none, gzip or zstd")
+	f.filter = registerFilterFlags(fs, "upload")
+	return f
+}
+
+// options validates the flags and returns the upload options they describe.
+func (f *uploadFlags) options() (pkg.UploadOptions, error) {
+	if _, err := pkg.NewChecksum(f.checksum); err != nil {
+		return pkg.UploadOptions{}, usageError(err.Error())
+	}
+	if err := pkg.ValidateCompression(f.compression); err != nil {
+		return pkg.UploadOptions{}, usageError(err.Error())
+	}
+	filter, err := f.filter.filter()
+	if err != nil {
+		return pkg.UploadOptions{}, err
+	}
+	key, err := pkg.LoadEncryptionKey(f.keyFile)
+	if err != nil {
+		return pkg.UploadOptions{}, fmt.Errorf("failed to load encryption key: %v", err)
+	}
+	return pkg.UploadOptions{
+		BufferSize:        f.bufferSize,
+		MemoryLimit:       f.memoryLimit,
+		Concurrency:       f.concurrency,
+		ChecksumAlgorithm: f.checksum,
+		EncryptionKey:     key,
+		Compression:       f.compression,
+		Filter:            filter,
+	}, nil
+}
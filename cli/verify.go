@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"github.com/tennix/tidb-cloud-backup/pkg"
+)
+
+var verifyCommand = &command{
+	name:    "verify",
+	summary: "Check that a backup in the bucket is complete and intact without downloading it",
+	setFlags: func(fs *flag.FlagSet) func(context.Context, *pkg.StorageFlags) error {
+		var (
+			backup string
+			opts   pkg.VerifyOptions
+		)
+		fs.StringVar(&backup, "backup", "", "Name of the backup in the bucket")
+		fs.BoolVar(&opts.Checksum, "checksum", false, "Also check the content of every object, using the MD5 computed by the provider where available and reading the object otherwise")
+		fs.BoolVar(&opts.NoProviderMD5, "no-provider-md5", false, "With --checksum, read and hash every object instead of trusting the MD5 computed by the provider, always the case with the file and azure providers")
+		fs.IntVar(&opts.Concurrency, "concurrency", 4, "Number of objects checked in parallel")
+
+		return func(ctx context.Context, storage *pkg.StorageFlags) error {
+			if backup == "" {
+				return usageError("--backup is required")
+			}
+			if opts.NoProviderMD5 && !opts.Checksum {
+				return usageError("--no-provider-md5 requires --checksum")
+			}
+			if !pkg.ProviderComputesMD5(storage.Cloud) {
+				opts.NoProviderMD5 = true
+			}
+			b, err := openBucket(ctx, storage)
+			if err != nil {
+				return err
+			}
+
+			manifest, err := pkg.Verify(ctx, b, backup, opts)
+			if err != nil {
+				return err
+			}
+			if opts.Checksum {
+				log.Printf("Backup %s is complete and intact: %d files (%d bytes)", backup, len(manifest.Objects), manifest.TotalSize())
+			} else {
+				log.Printf("Backup %s is complete: %d files (%d bytes)", backup, len(manifest.Objects), manifest.TotalSize())
+			}
+			return nil
+		}
+	},
+}
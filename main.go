@@ -0,0 +1,13 @@
+// Command tidb-cloud-backup uploads, downloads and manages TiDB backups stored
+// in cloud object storage.
+package main
+
+import (
+	"os"
+
+	"github.com/tennix/tidb-cloud-backup/cli"
+)
+
+func main() {
+	os.Exit(cli.Main(os.Args[1:]))
+}
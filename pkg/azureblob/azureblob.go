@@ -0,0 +1,404 @@
+// Package azureblob provides a blob implementation for Azure Blob Storage,
+// following the semantics of the gocloud.dev s3blob and gcsblob providers.
+package azureblob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+const (
+	defaultPageSize = 1000
+	// defaultBufferSize is the block size used by writers when the caller
+	// does not set WriterOptions.BufferSize.
+	defaultBufferSize = 5 << 20
+	// maxDownloadRetries is the number of times a broken download stream is
+	// resumed from the last received byte.
+	maxDownloadRetries = 3
+)
+
+// Options sets options for opening a container.
+type Options struct {
+	// AccountName is the storage account holding the container.
+	AccountName string
+	// AccountKey authenticates with the shared key of the account. Either
+	// AccountKey or SASToken must be set.
+	AccountKey string
+	// SASToken authenticates with a shared access signature.
+	SASToken string
+	// Endpoint overrides the default https://<account>.blob.core.windows.net
+	// service URL, e.g. for sovereign clouds or the Azurite emulator.
+	Endpoint string
+}
+
+// OpenBucket returns a *blob.Bucket backed by the Azure Blob container.
+func OpenBucket(ctx context.Context, container string, opts *Options) (*blob.Bucket, error) {
+	if opts == nil || opts.AccountName == "" {
+		return nil, errors.New("azureblob: account name is required")
+	}
+	var (
+		cred azblob.Credential
+		err  error
+	)
+	switch {
+	case opts.AccountKey != "":
+		if cred, err = azblob.NewSharedKeyCredential(opts.AccountName, opts.AccountKey); err != nil {
+			return nil, fmt.Errorf("azureblob: invalid account key: %v", err)
+		}
+	case opts.SASToken != "":
+		cred = azblob.NewAnonymousCredential()
+	default:
+		return nil, errors.New("azureblob: an account key or a SAS token is required")
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", opts.AccountName)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("azureblob: invalid endpoint %s: %v", endpoint, err)
+	}
+	if opts.SASToken != "" {
+		u.RawQuery = strings.TrimPrefix(opts.SASToken, "?")
+	}
+	p := azblob.NewPipeline(cred, azblob.PipelineOptions{})
+	b := &bucket{
+		name:         container,
+		containerURL: azblob.NewServiceURL(*u, p).NewContainerURL(container),
+	}
+	return blob.NewBucket(b), nil
+}
+
+type bucket struct {
+	name         string
+	containerURL azblob.ContainerURL
+}
+
+func (b *bucket) blobURL(key string) azblob.BlockBlobURL {
+	return b.containerURL.NewBlockBlobURL(key)
+}
+
+// ErrorCode implements driver.ErrorCode.
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	serr, ok := err.(azblob.StorageError)
+	if !ok {
+		return gcerrors.Unknown
+	}
+	switch {
+	case serr.ServiceCode() == azblob.ServiceCodeBlobNotFound,
+		serr.ServiceCode() == azblob.ServiceCodeContainerNotFound,
+		serr.Response() != nil && serr.Response().StatusCode == http.StatusNotFound:
+		return gcerrors.NotFound
+	case serr.Response() != nil && (serr.Response().StatusCode == http.StatusForbidden || serr.Response().StatusCode == http.StatusUnauthorized):
+		return gcerrors.PermissionDenied
+	default:
+		return gcerrors.Unknown
+	}
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool {
+	p, ok := i.(*azblob.ContainerURL)
+	if !ok {
+		return false
+	}
+	*p = b.containerURL
+	return true
+}
+
+// ErrorAs implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	serr, ok := err.(azblob.StorageError)
+	if !ok {
+		return false
+	}
+	p, ok := i.(*azblob.StorageError)
+	if !ok {
+		return false
+	}
+	*p = serr
+	return true
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (driver.Attributes, error) {
+	resp, err := b.blobURL(key).GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return driver.Attributes{}, err
+	}
+	md := make(map[string]string, len(resp.NewMetadata()))
+	for k, v := range resp.NewMetadata() {
+		md[unescapeMetadataKey(k)] = v
+	}
+	return driver.Attributes{
+		CacheControl:       resp.CacheControl(),
+		ContentDisposition: resp.ContentDisposition(),
+		ContentEncoding:    resp.ContentEncoding(),
+		ContentLanguage:    resp.ContentLanguage(),
+		ContentType:        resp.ContentType(),
+		Metadata:           md,
+		ModTime:            resp.LastModified(),
+		Size:               resp.ContentLength(),
+		MD5:                resp.ContentMD5(),
+		AsFunc: func(i interface{}) bool {
+			p, ok := i.(*azblob.BlobGetPropertiesResponse)
+			if !ok {
+				return false
+			}
+			*p = *resp
+			return true
+		},
+	}, nil
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	marker := azblob.Marker{}
+	if len(opts.PageToken) > 0 {
+		token := string(opts.PageToken)
+		marker.Val = &token
+	}
+	listOpts := azblob.ListBlobsSegmentOptions{
+		Prefix:     opts.Prefix,
+		MaxResults: int32(pageSize),
+	}
+	if opts.BeforeList != nil {
+		if err := opts.BeforeList(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &driver.ListPage{}
+	var (
+		items      []azblob.BlobItemInternal
+		nextMarker azblob.Marker
+	)
+	if opts.Delimiter == "" {
+		resp, err := b.containerURL.ListBlobsFlatSegment(ctx, marker, listOpts)
+		if err != nil {
+			return nil, err
+		}
+		items, nextMarker = resp.Segment.BlobItems, resp.NextMarker
+	} else {
+		resp, err := b.containerURL.ListBlobsHierarchySegment(ctx, marker, opts.Delimiter, listOpts)
+		if err != nil {
+			return nil, err
+		}
+		items, nextMarker = resp.Segment.BlobItems, resp.NextMarker
+		for _, prefix := range resp.Segment.BlobPrefixes {
+			page.Objects = append(page.Objects, &driver.ListObject{
+				Key:   prefix.Name,
+				IsDir: true,
+			})
+		}
+	}
+	for _, item := range items {
+		obj := &driver.ListObject{
+			Key:     item.Name,
+			ModTime: item.Properties.LastModified,
+			MD5:     item.Properties.ContentMD5,
+		}
+		if item.Properties.ContentLength != nil {
+			obj.Size = *item.Properties.ContentLength
+		}
+		page.Objects = append(page.Objects, obj)
+	}
+	if nextMarker.NotDone() {
+		page.NextPageToken = []byte(*nextMarker.Val)
+	}
+	return page, nil
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	count := length
+	if count < 0 {
+		count = azblob.CountToEnd
+	}
+	resp, err := b.blobURL(key).Download(ctx, offset, count, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, err
+	}
+	attrs := driver.ReaderAttributes{
+		ContentType: resp.ContentType(),
+		ModTime:     resp.LastModified(),
+		Size:        resp.ContentLength(),
+	}
+	// For range requests the total size is the part after the slash of
+	// Content-Range: bytes <start>-<end>/<size>.
+	if cr := resp.ContentRange(); cr != "" {
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			fmt.Sscanf(cr[i+1:], "%d", &attrs.Size)
+		}
+	}
+	var body io.ReadCloser
+	if length == 0 {
+		resp.Response().Body.Close()
+		body = http.NoBody
+	} else {
+		body = resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: maxDownloadRetries})
+	}
+	return &reader{body: body, attrs: attrs, raw: resp}, nil
+}
+
+type reader struct {
+	body  io.ReadCloser
+	attrs driver.ReaderAttributes
+	raw   *azblob.DownloadResponse
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.body.Read(p)
+}
+
+func (r *reader) Close() error {
+	return r.body.Close()
+}
+
+func (r *reader) Attributes() driver.ReaderAttributes {
+	return r.attrs
+}
+
+func (r *reader) As(i interface{}) bool {
+	p, ok := i.(*azblob.DownloadResponse)
+	if !ok {
+		return false
+	}
+	*p = *r.raw
+	return true
+}
+
+// NewTypedWriter implements driver.NewTypedWriter. Data is streamed as
+// blocks of BufferSize bytes; the block list is only committed by Close, so
+// an aborted write leaves no blob behind.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	bufferSize := opts.BufferSize
+	if bufferSize == 0 {
+		bufferSize = defaultBufferSize
+	}
+	md := make(azblob.Metadata, len(opts.Metadata))
+	for k, v := range opts.Metadata {
+		md[escapeMetadataKey(k)] = v
+	}
+	uploadOpts := azblob.UploadStreamToBlockBlobOptions{
+		BufferSize: bufferSize,
+		MaxBuffers: 1,
+		Metadata:   md,
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentLanguage:    opts.ContentLanguage,
+			ContentMD5:         opts.ContentMD5,
+			ContentType:        contentType,
+		},
+	}
+	if opts.BeforeWrite != nil {
+		asFunc := func(i interface{}) bool {
+			p, ok := i.(**azblob.UploadStreamToBlockBlobOptions)
+			if !ok {
+				return false
+			}
+			*p = &uploadOpts
+			return true
+		}
+		if err := opts.BeforeWrite(asFunc); err != nil {
+			return nil, err
+		}
+	}
+	return &writer{
+		ctx:     ctx,
+		blobURL: b.blobURL(key),
+		opts:    uploadOpts,
+	}, nil
+}
+
+type writer struct {
+	ctx     context.Context
+	blobURL azblob.BlockBlobURL
+	opts    azblob.UploadStreamToBlockBlobOptions
+
+	pw    *io.PipeWriter
+	donec chan struct{}
+	err   error
+}
+
+// open starts the upload goroutine reading from a pipe fed by Write.
+func (w *writer) open() {
+	pr, pw := io.Pipe()
+	w.pw = pw
+	w.donec = make(chan struct{})
+	go func() {
+		defer close(w.donec)
+		_, w.err = azblob.UploadStreamToBlockBlob(w.ctx, pr, w.blobURL, w.opts)
+		if w.err != nil {
+			pr.CloseWithError(w.err)
+			return
+		}
+		pr.Close()
+	}()
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.pw == nil {
+		w.open()
+	}
+	return w.pw.Write(p)
+}
+
+func (w *writer) Close() error {
+	if w.pw == nil {
+		// Nothing was written; still create an empty blob.
+		w.open()
+	}
+	w.pw.Close()
+	<-w.donec
+	if w.err == nil && w.ctx.Err() != nil {
+		return w.ctx.Err()
+	}
+	return w.err
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string) error {
+	_, err := b.blobURL(key).Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return err
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", errors.New("azureblob: SignedURL is not supported")
+}
+
+// Ensure the driver is complete at compile time.
+var _ driver.Bucket = (*bucket)(nil)
+
+// Azure metadata keys must be valid C# identifiers, so the dashes of keys
+// such as "encryption-key-id" are escaped the same way as the Go CDK does.
+const escapedDash = "__0x2d__"
+
+func escapeMetadataKey(k string) string {
+	return strings.Replace(k, "-", escapedDash, -1)
+}
+
+func unescapeMetadataKey(k string) string {
+	return strings.Replace(k, escapedDash, "-", -1)
+}
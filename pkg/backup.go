@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// BackupTimeFormat is the layout of the timestamp naming backups.
+const BackupTimeFormat = "2006-01-02T150405"
+
+// DefaultBackupNamePrefix is prepended to the timestamp naming backups.
+const DefaultBackupNamePrefix = "tidb_backup_"
+
+// BackupOptions configures Backup.
+type BackupOptions struct {
+	Dump   DumpOptions
+	Upload UploadOptions
+	// WorkDir is the local directory the dump is written to before being
+	// uploaded.
+	WorkDir string
+	// NamePrefix is prepended to the timestamp naming the backup.
+	NamePrefix string
+}
+
+// BackupName returns the name of a backup started at t: prefix, the time and
+// a random suffix, so that backups started within the same second, e.g. by
+// clusters sharing a bucket, get different names.
+func BackupName(prefix string, t time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return prefix + t.UTC().Format(BackupTimeFormat) + "_" + hex.EncodeToString(suffix), nil
+}
+
+// BackupExists reports whether any object is stored under the prefix of the
+// backup name.
+func BackupExists(ctx context.Context, b *blob.Bucket, name string) (bool, error) {
+	name = strings.TrimSuffix(name, "/")
+	_, err := b.List(&blob.ListOptions{Prefix: name + "/"}).Next(ctx)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Backup dumps the database with mydumper, uploads the dump as a backup named
+// after the current time and removes the local dump. It is atomic: when any
+// step fails, the objects already uploaded are deleted so that no partial
+// backup is left in the bucket. The upload is refused if objects already exist
+// under the backup name, since they would be deleted along with it.
+func Backup(ctx context.Context, b *blob.Bucket, opts BackupOptions) (*Manifest, error) {
+	name, err := BackupName(opts.NamePrefix, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(opts.WorkDir, name)
+	if _, err := os.Stat(dir); err == nil {
+		return nil, fmt.Errorf("dump directory %s already exists", dir)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Failed to remove dump directory %s: %v", dir, err)
+		}
+	}()
+
+	if err = Dump(ctx, dir, opts.Dump); err != nil {
+		return nil, err
+	}
+	exists, err := BackupExists(ctx, b, name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("backup %s already exists in the bucket", name)
+	}
+	manifest, err := NewUploader(b, opts.Upload).UploadDir(ctx, dir, name)
+	if err != nil {
+		// The upload may have failed because ctx was cancelled, clean up
+		// regardless.
+		if derr := DeleteBackup(context.Background(), b, name); derr != nil {
+			log.Printf("Failed to delete partial backup %s: %v", name, derr)
+		}
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// ListBackups returns the names of the backups stored in the bucket, i.e. its
+// top-level prefixes, in lexical order.
+func ListBackups(ctx context.Context, b *blob.Bucket) ([]string, error) {
+	var names []string
+	iter := b.List(&blob.ListOptions{Delimiter: "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if obj.IsDir {
+			names = append(names, strings.TrimSuffix(obj.Key, "/"))
+		}
+	}
+}
+
+// DeleteBackup deletes every object of the backup under prefix. The manifest
+// is deleted first so that an interrupted deletion leaves an incomplete backup
+// rather than one that looks complete. Objects failing to be deleted are
+// returned as TransferErrors.
+func DeleteBackup(ctx context.Context, b *blob.Bucket, prefix string) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if err := b.Delete(ctx, ManifestKey(prefix)); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return err
+	}
+	var errs TransferErrors
+	iter := b.List(&blob.ListOptions{Prefix: prefix + "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = b.Delete(ctx, obj.Key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			log.Printf("Delete file: %s failed, error: %v", obj.Key, err)
+			errs = append(errs, &FileError{Name: obj.Key, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Backup statuses reported by DescribeBackup.
+const (
+	// StatusComplete is a backup whose objects all match its manifest.
+	StatusComplete = "complete"
+	// StatusIncomplete is a backup without a manifest, either still being
+	// uploaded or abandoned by a failed upload.
+	StatusIncomplete = "incomplete"
+	// StatusDamaged is a backup with objects missing or not matching its
+	// manifest, or whose manifest cannot be read.
+	StatusDamaged = "damaged"
+)
+
+// BackupInfo summarizes a backup stored in a bucket.
+type BackupInfo struct {
+	Name string `json:"name"`
+	// Time is the start time of the backup upload, or the time of its
+	// oldest object for backups without a manifest.
+	Time    time.Time     `json:"time"`
+	Size    int64         `json:"size"`
+	Objects int           `json:"objects"`
+	Status  string        `json:"status"`
+	Dump    *DumpMetadata `json:"dump,omitempty"`
+	// Filter holds the patterns a filtered backup was uploaded with.
+	Filter *ManifestFilter `json:"filter,omitempty"`
+	// Error is why a damaged backup could not be described.
+	Error string `json:"error,omitempty"`
+}
+
+// DescribeBackup summarizes the backup under prefix from its manifest and a
+// listing of its objects.
+func DescribeBackup(ctx context.Context, b *blob.Bucket, prefix string) (*BackupInfo, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	m, err := ReadManifest(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := listObjects(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BackupInfo{Name: prefix}
+	if m == nil {
+		info.Status = StatusIncomplete
+		for _, obj := range objs {
+			info.Size += obj.Size
+			if info.Time.IsZero() || obj.ModTime.Before(info.Time) {
+				info.Time = obj.ModTime.UTC()
+			}
+		}
+		info.Objects = len(objs)
+		return info, nil
+	}
+	info.Time = m.StartTime
+	info.Size = m.TotalSize()
+	info.Objects = len(m.Objects)
+	info.Dump = m.Dump
+	info.Filter = m.Filter
+	if m.check(prefix, objs) != nil {
+		info.Status = StatusDamaged
+	} else {
+		info.Status = StatusComplete
+	}
+	return info, nil
+}
+
+// DescribeBackups summarizes every backup stored in the bucket, in the order
+// of ListBackups. A backup that cannot be described, e.g. because its manifest
+// is corrupted, is reported as damaged with the error rather than failing the
+// others, along with the dump metadata recorded on its manifest object.
+func DescribeBackups(ctx context.Context, b *blob.Bucket) ([]*BackupInfo, error) {
+	names, err := ListBackups(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*BackupInfo, 0, len(names))
+	for _, name := range names {
+		info, err := DescribeBackup(ctx, b, name)
+		if err != nil {
+			log.Printf("Describe backup: %s failed, error: %v", name, err)
+			info = &BackupInfo{Name: name, Status: StatusDamaged, Error: err.Error()}
+			// The dump metadata recorded alongside a corrupted manifest
+			// still tells where point-in-time recovery would start.
+			info.Dump, _ = ReadManifestDump(ctx, b, name)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBudget bounds the total amount of memory that may be held by
+// in-flight blob writers. A zero limit disables the budget.
+type MemoryBudget struct {
+	mu     sync.Mutex
+	limit  int64
+	used   int64
+	notify chan struct{}
+}
+
+// NewMemoryBudget returns a budget allowing at most limit bytes to be acquired
+// at the same time.
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{
+		limit:  limit,
+		notify: make(chan struct{}),
+	}
+}
+
+// Acquire blocks until n bytes are available or ctx is done. A request larger
+// than the whole budget is clamped to the limit, so it can still proceed once
+// everything else has been released.
+func (m *MemoryBudget) Acquire(ctx context.Context, n int64) error {
+	if m.limit <= 0 {
+		return nil
+	}
+	if n > m.limit {
+		n = m.limit
+	}
+	for {
+		m.mu.Lock()
+		if m.used+n <= m.limit {
+			m.used += n
+			m.mu.Unlock()
+			return nil
+		}
+		ch := m.notify
+		m.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// Release returns n bytes previously obtained with Acquire.
+func (m *MemoryBudget) Release(n int64) {
+	if m.limit <= 0 {
+		return
+	}
+	if n > m.limit {
+		n = m.limit
+	}
+	m.mu.Lock()
+	m.used -= n
+	close(m.notify)
+	m.notify = make(chan struct{})
+	m.mu.Unlock()
+}
@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CheckpointPath returns the path of the checkpoint file of an upload of dir,
+// next to it.
+func CheckpointPath(dir string) string {
+	return filepath.Clean(dir) + ".checkpoint"
+}
+
+// checkpointHeader is the first line of a checkpoint file. Its entries are
+// only reused by an upload with the same header, since the objects would be
+// stored differently otherwise.
+type checkpointHeader struct {
+	Prefix            string `json:"prefix"`
+	ChecksumAlgorithm string `json:"checksum_algorithm"`
+	Compression       string `json:"compression"`
+	EncryptionKeyID   string `json:"encryption_key_id,omitempty"`
+}
+
+// checkpointEntry records a file uploaded successfully, followed by the size
+// and modification time of the local file when it was uploaded.
+type checkpointEntry struct {
+	Object        ManifestObject `json:"object"`
+	SourceSize    int64          `json:"source_size"`
+	SourceModTime time.Time      `json:"source_mtime"`
+}
+
+// checkpoint is a file recording the progress of an upload, one JSON line per
+// uploaded file, so that an interrupted upload can skip them when run again.
+type checkpoint struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+	done map[string]checkpointEntry
+}
+
+// openCheckpoint loads the entries of the checkpoint file at path if it was
+// written by an upload with the same header, and opens it for recording.
+func openCheckpoint(path string, header checkpointHeader) (*checkpoint, error) {
+	cp := &checkpoint{path: path, done: make(map[string]checkpointEntry)}
+	if f, err := os.Open(path); err == nil {
+		dec := json.NewDecoder(f)
+		var h checkpointHeader
+		if err = dec.Decode(&h); err == nil && h == header {
+			for {
+				var e checkpointEntry
+				// A truncated last line is left by an interrupted
+				// write, the entries before it are still valid.
+				if err = dec.Decode(&e); err != nil {
+					break
+				}
+				cp.done[e.Object.Key] = e
+			}
+			log.Printf("Resuming upload from checkpoint %s with %d file(s) uploaded", path, len(cp.done))
+		} else {
+			log.Printf("Ignoring checkpoint %s of another upload", path)
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Rewrite the file from the entries loaded, dropping anything invalid.
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	cp.f = f
+	cp.enc = json.NewEncoder(f)
+	if err = cp.enc.Encode(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	for _, e := range cp.done {
+		if err = cp.enc.Encode(e); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return cp, nil
+}
+
+// lookup returns the entry of key if the local file has not changed since it
+// was uploaded.
+func (cp *checkpoint) lookup(key string, info os.FileInfo) (checkpointEntry, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	e, ok := cp.done[key]
+	if !ok || e.SourceSize != info.Size() || !e.SourceModTime.Equal(info.ModTime()) {
+		return checkpointEntry{}, false
+	}
+	return e, true
+}
+
+// record appends the entry of an uploaded file and syncs it to disk.
+func (cp *checkpoint) record(obj ManifestObject, info os.FileInfo) error {
+	e := checkpointEntry{Object: obj, SourceSize: info.Size(), SourceModTime: info.ModTime().UTC()}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.done[obj.Key] = e
+	if err := cp.enc.Encode(e); err != nil {
+		return err
+	}
+	return cp.f.Sync()
+}
+
+// close closes the checkpoint file, and removes it once the upload has
+// completed.
+func (cp *checkpoint) close(completed bool) {
+	if err := cp.f.Close(); err != nil {
+		log.Printf("Failed to write checkpoint %s: %v", cp.path, err)
+	}
+	if completed {
+		if err := os.Remove(cp.path); err != nil {
+			log.Printf("Failed to remove checkpoint %s: %v", cp.path, err)
+		}
+	}
+}
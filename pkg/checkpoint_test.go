@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fileInfo is the os.FileInfo of a local file as seen by the checkpoint.
+type fileInfo struct {
+	os.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+
+var testHeader = checkpointHeader{Prefix: "backup", ChecksumAlgorithm: "sha256", Compression: CompressionNone}
+
+// openTestCheckpoint opens the checkpoint at path, failing the test on error.
+func openTestCheckpoint(t *testing.T, path string, header checkpointHeader) *checkpoint {
+	cp, err := openCheckpoint(path, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cp
+}
+
+func TestCheckpointReopen(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "backup.checkpoint")
+	mtime := time.Date(2019, 2, 13, 6, 11, 40, 0, time.UTC)
+	info := fileInfo{size: 100, modTime: mtime}
+
+	cp := openTestCheckpoint(t, path, testHeader)
+	if err := cp.record(ManifestObject{Key: "backup/a.sql", Size: 100, Checksum: "aa"}, info); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.record(ManifestObject{Key: "backup/b.sql", Size: 10, Checksum: "bb"}, fileInfo{size: 10, modTime: mtime}); err != nil {
+		t.Fatal(err)
+	}
+	cp.close(false)
+
+	cp = openTestCheckpoint(t, path, testHeader)
+	defer cp.close(false)
+	if len(cp.done) != 2 {
+		t.Fatalf("reopened checkpoint has %d entries, want 2", len(cp.done))
+	}
+	for _, tc := range []struct {
+		name string
+		key  string
+		info os.FileInfo
+		ok   bool
+	}{
+		{"unchanged", "backup/a.sql", info, true},
+		{"other size", "backup/a.sql", fileInfo{size: 101, modTime: mtime}, false},
+		{"other mtime", "backup/a.sql", fileInfo{size: 100, modTime: mtime.Add(time.Second)}, false},
+		{"mtime in another zone", "backup/a.sql", fileInfo{size: 100, modTime: mtime.In(time.FixedZone("UTC+8", 8*3600))}, true},
+		{"not recorded", "backup/c.sql", info, false},
+	} {
+		e, ok := cp.lookup(tc.key, tc.info)
+		if ok != tc.ok {
+			t.Errorf("%s: lookup = %v, want %v", tc.name, ok, tc.ok)
+		} else if ok && e.Object.Checksum != "aa" {
+			t.Errorf("%s: lookup returned %+v", tc.name, e.Object)
+		}
+	}
+}
+
+func TestCheckpointTruncatedLine(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "backup.checkpoint")
+	info := fileInfo{size: 1, modTime: time.Now()}
+
+	cp := openTestCheckpoint(t, path, testHeader)
+	if err := cp.record(ManifestObject{Key: "backup/a.sql", Size: 1}, info); err != nil {
+		t.Fatal(err)
+	}
+	cp.close(false)
+	// An interrupted write leaves a partial last line.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"object":{"key":"backup/b.sql","si`)
+	f.Close()
+
+	cp = openTestCheckpoint(t, path, testHeader)
+	if _, ok := cp.lookup("backup/a.sql", info); !ok || len(cp.done) != 1 {
+		t.Fatalf("checkpoint has %d entries, want backup/a.sql only", len(cp.done))
+	}
+	if err = cp.record(ManifestObject{Key: "backup/c.sql", Size: 1}, info); err != nil {
+		t.Fatal(err)
+	}
+	cp.close(false)
+
+	// The partial line is dropped when the file is rewritten, so the
+	// entries recorded after it are read back.
+	cp = openTestCheckpoint(t, path, testHeader)
+	defer cp.close(false)
+	if len(cp.done) != 2 {
+		t.Fatalf("checkpoint has %d entries, want 2", len(cp.done))
+	}
+}
+
+func TestCheckpointOtherUpload(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "backup.checkpoint")
+	info := fileInfo{size: 1, modTime: time.Now()}
+
+	cp := openTestCheckpoint(t, path, testHeader)
+	if err := cp.record(ManifestObject{Key: "backup/a.sql", Size: 1}, info); err != nil {
+		t.Fatal(err)
+	}
+	cp.close(false)
+
+	for _, header := range []checkpointHeader{
+		{Prefix: "other", ChecksumAlgorithm: "sha256", Compression: CompressionNone},
+		{Prefix: "backup", ChecksumAlgorithm: "sha256", Compression: CompressionZstd},
+		{Prefix: "backup", ChecksumAlgorithm: "sha256", Compression: CompressionNone, EncryptionKeyID: "key"},
+	} {
+		cp = openTestCheckpoint(t, path, header)
+		if len(cp.done) != 0 {
+			t.Errorf("checkpoint of another upload reused for %+v", header)
+		}
+		cp.close(false)
+	}
+}
+
+func TestCheckpointRemovedOnCompletion(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "backup.checkpoint")
+
+	openTestCheckpoint(t, path, testHeader).close(false)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("checkpoint of an incomplete upload removed: %v", err)
+	}
+	openTestCheckpoint(t, path, testHeader).close(true)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint of a completed upload kept: %v", err)
+	}
+}
+
+func TestUploadedChecksBucket(t *testing.T) {
+	ctx := context.Background()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	b := newFileBucket(t, filepath.Join(dir, "store"))
+	u := NewUploader(b, UploadOptions{})
+	info := fileInfo{size: 5, modTime: time.Now()}
+
+	cp := openTestCheckpoint(t, filepath.Join(dir, "backup.checkpoint"), testHeader)
+	defer cp.close(false)
+	for _, key := range []string{"backup/kept", "backup/resized", "backup/deleted"} {
+		if err := cp.record(ManifestObject{Key: key, Size: 5}, info); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.WriteAll(ctx, "backup/kept", []byte("12345"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "backup/resized", []byte("1234"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]bool{"backup/kept": true, "backup/resized": false, "backup/deleted": false} {
+		if _, ok := u.uploaded(ctx, cp, key, info); ok != want {
+			t.Errorf("uploaded(%s) = %v, want %v", key, ok, want)
+		}
+	}
+}
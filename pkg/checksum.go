@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+// Checksum algorithms supported in backup manifests.
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumCRC32C = "crc32c"
+)
+
+// DefaultChecksumAlgorithm is used when no algorithm is configured.
+const DefaultChecksumAlgorithm = ChecksumSHA256
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NewChecksum returns a hash implementing the named checksum algorithm.
+func NewChecksum(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), nil
+	default:
+		return nil, fmt.Errorf("invalid checksum algorithm: %s", algorithm)
+	}
+}
+
+// ChecksumError reports an object whose content does not match the checksum
+// recorded in the manifest.
+type ChecksumError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected %s, got %s", e.Key, e.Expected, e.Actual)
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs applied to uploaded objects.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// The codec is recorded in object metadata rather than Content-Encoding: GCS
+// transparently decompresses gzip-encoded objects on read, which would break
+// the size and checksum recorded in the manifest.
+const metaCompression = "compression"
+
+// compressionFootprint approximates the memory held by one compressing
+// stream; zstd keeps up to an 8 MiB window at the default level.
+const compressionFootprint = 8 << 20
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ValidateCompression checks that codec is a supported compression codec.
+func ValidateCompression(codec string) error {
+	switch codec {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	default:
+		return fmt.Errorf("invalid compression codec: %s", codec)
+	}
+}
+
+// isCompressed reports whether header starts like a gzip or zstd stream, as
+// produced by e.g. mydumper --compress. Such files are uploaded as is.
+func isCompressed(header []byte) bool {
+	return bytes.HasPrefix(header, gzipMagic) || bytes.HasPrefix(header, zstdMagic)
+}
+
+// newCompressor returns a writer compressing everything written to it into w
+// with codec. Close flushes the stream; it does not close w.
+func newCompressor(w io.Writer, codec string) (io.WriteCloser, error) {
+	switch codec {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("invalid compression codec: %s", codec)
+	}
+}
+
+// decompressor returns a reader decompressing r according to the object
+// metadata md, or r itself if the object was not compressed.
+func decompressor(r io.Reader, md map[string]string) (io.ReadCloser, error) {
+	switch codec := md[metaCompression]; codec {
+	case "", CompressionNone:
+		return ioutil.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression codec: %s", codec)
+	}
+}
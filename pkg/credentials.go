@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"gocloud.dev/gcp"
+	"golang.org/x/oauth2/google"
+)
+
+// AWS credential sources.
+const (
+	// CredentialsEnv reads AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+	CredentialsEnv = "env"
+	// CredentialsShared reads a profile of the shared credentials file.
+	CredentialsShared = "shared"
+	// CredentialsWebIdentity assumes AWS_ROLE_ARN with the token in
+	// AWS_WEB_IDENTITY_TOKEN_FILE, as set up by EKS IAM roles for service
+	// accounts.
+	CredentialsWebIdentity = "web-identity"
+	// CredentialsEC2 uses the instance role from the EC2 metadata service.
+	CredentialsEC2 = "ec2"
+
+	defaultRoleSessionName = "tidb-cloud-backup"
+	gcpScope               = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// DefaultAWSCredentialSources are the AWS credential sources tried, in order,
+// when none are given.
+var DefaultAWSCredentialSources = []string{CredentialsEnv, CredentialsShared, CredentialsWebIdentity, CredentialsEC2}
+
+// CredentialOptions selects where the credentials of a provider come from.
+type CredentialOptions struct {
+	// AWSSources lists the AWS credential sources tried in order, the
+	// DefaultAWSCredentialSources if empty.
+	AWSSources []string
+	// AWSProfile is the profile read from the shared credentials file,
+	// AWS_PROFILE or "default" if empty.
+	AWSProfile string
+	// AWSSharedCredentialsFile is the shared credentials file,
+	// AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials if empty.
+	AWSSharedCredentialsFile string
+	// AWSRoleARN is a role assumed with the credentials found, to access a
+	// bucket of another account.
+	AWSRoleARN string
+	// AWSExternalID is passed when assuming AWSRoleARN, if required by its
+	// trust policy.
+	AWSExternalID string
+	// AWSRoleSessionName names the session of the assumed roles.
+	AWSRoleSessionName string
+	// GCPCredentialsFile is a service account JSON key file. The
+	// application default credentials are used if empty.
+	GCPCredentialsFile string
+}
+
+// AWSCredentials returns credentials from the first source of opts providing
+// some, exchanged for the credentials of opts.AWSRoleARN if set. The region is
+// used to reach STS.
+func AWSCredentials(opts CredentialOptions, region string) (*credentials.Credentials, error) {
+	s, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	sessionName := opts.AWSRoleSessionName
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+	sources := opts.AWSSources
+	if len(sources) == 0 {
+		sources = DefaultAWSCredentialSources
+	}
+
+	chain := &credentialChain{names: sources}
+	for _, source := range sources {
+		var p credentials.Provider
+		switch source {
+		case CredentialsEnv:
+			p = &credentials.EnvProvider{}
+		case CredentialsShared:
+			p = &credentials.SharedCredentialsProvider{
+				Filename: opts.AWSSharedCredentialsFile,
+				Profile:  opts.AWSProfile,
+			}
+		case CredentialsWebIdentity:
+			p = &webIdentityProvider{
+				client:      sts.New(s, aws.NewConfig().WithCredentials(credentials.AnonymousCredentials)),
+				tokenFile:   os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+				roleARN:     os.Getenv("AWS_ROLE_ARN"),
+				sessionName: sessionName,
+			}
+		case CredentialsEC2:
+			p = &ec2rolecreds.EC2RoleProvider{Client: ec2metadata.New(s)}
+		default:
+			return nil, fmt.Errorf("invalid AWS credential source: %s", source)
+		}
+		chain.providers = append(chain.providers, p)
+	}
+	creds := credentials.NewCredentials(chain)
+	if _, err := creds.Get(); err != nil {
+		return nil, err
+	}
+
+	if opts.AWSRoleARN == "" {
+		return creds, nil
+	}
+	creds = stscreds.NewCredentials(s.Copy(aws.NewConfig().WithCredentials(creds)), opts.AWSRoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = sessionName
+		if opts.AWSExternalID != "" {
+			p.ExternalID = aws.String(opts.AWSExternalID)
+		}
+	})
+	if _, err := creds.Get(); err != nil {
+		return nil, fmt.Errorf("failed to assume role %s: %v", opts.AWSRoleARN, credentialError(err))
+	}
+	return creds, nil
+}
+
+// GCPCredentials returns the credentials of the service account key file of
+// opts, or the application default credentials if none is given.
+func GCPCredentials(ctx context.Context, opts CredentialOptions) (*google.Credentials, error) {
+	if opts.GCPCredentialsFile != "" {
+		data, err := ioutil.ReadFile(opts.GCPCredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GCP credentials file: %v", err)
+		}
+		return google.CredentialsFromJSON(ctx, data, gcpScope)
+	}
+	// DefaultCredentials assumes a user has logged in with gcloud.
+	// See here for more information:
+	// https://cloud.google.com/docs/authentication/getting-started
+	creds, err := gcp.DefaultCredentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("no GCP credentials found, tried: GOOGLE_APPLICATION_CREDENTIALS, gcloud application default credentials and the GCE metadata server: %v", err)
+	}
+	return creds, nil
+}
+
+// credentialChain returns the credentials of the first provider having some,
+// and reports every provider tried otherwise.
+type credentialChain struct {
+	names     []string
+	providers []credentials.Provider
+	current   credentials.Provider
+}
+
+func (c *credentialChain) Retrieve() (credentials.Value, error) {
+	var tried []string
+	for i, p := range c.providers {
+		v, err := p.Retrieve()
+		if err == nil {
+			c.current = p
+			return v, nil
+		}
+		tried = append(tried, fmt.Sprintf("%s (%v)", c.names[i], credentialError(err)))
+	}
+	c.current = nil
+	return credentials.Value{}, fmt.Errorf("no AWS credentials found, tried: %s", strings.Join(tried, ", "))
+}
+
+func (c *credentialChain) IsExpired() bool {
+	if c.current == nil {
+		return true
+	}
+	return c.current.IsExpired()
+}
+
+// credentialError returns the message of AWS SDK errors without their code
+// and nested errors, which are too verbose to be listed for every source.
+func credentialError(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Message()
+	}
+	return err.Error()
+}
+
+// webIdentityProvider exchanges the token of a Kubernetes service account for
+// the credentials of the role it is bound to.
+type webIdentityProvider struct {
+	credentials.Expiry
+	client      *sts.STS
+	tokenFile   string
+	roleARN     string
+	sessionName string
+}
+
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	if p.tokenFile == "" || p.roleARN == "" {
+		return credentials.Value{}, errors.New("AWS_WEB_IDENTITY_TOKEN_FILE or AWS_ROLE_ARN not set")
+	}
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	out, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.sessionName),
+		WebIdentityToken: aws.String(string(token)),
+	})
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	p.SetExpiration(aws.TimeValue(out.Credentials.Expiration), time.Minute)
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(out.Credentials.SessionToken),
+		ProviderName:    "WebIdentityProvider",
+	}, nil
+}
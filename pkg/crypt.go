@@ -0,0 +1,334 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Encrypted objects are written as a header followed by a sequence of
+// independently sealed AES-256-GCM chunks:
+//
+//	header: magic "TCBE" | version (1 byte) | chunk size (uint32) | salt (32 bytes) | nonce prefix (7 bytes)
+//	chunk:  ciphertext of up to chunk size bytes | GCM tag (16 bytes)
+//
+// Each object is sealed with its own key, derived from the encryption key and
+// the random salt with HKDF-SHA256 as in Tink's AES-GCM-HKDF streaming AEAD,
+// so that nonces only need to be unique within an object rather than across
+// every object ever encrypted with the key. The nonce of chunk i is the nonce
+// prefix, i as a big-endian uint32 and a final byte set to 1 for the last
+// chunk only, so chunks cannot be reordered, dropped or truncated without
+// failing authentication.
+
+const (
+	// EncryptionAlgorithm is recorded in the object metadata of encrypted objects.
+	EncryptionAlgorithm = "aes-256-gcm-chunked"
+	// EncryptionKeyEnv is the environment variable holding the encryption
+	// key, hex or base64 encoded, when no key file is given.
+	EncryptionKeyEnv = "TIDB_BACKUP_ENCRYPTION_KEY"
+
+	// Object metadata keys describing the encryption of an object.
+	metaEncryption      = "encryption"
+	metaEncryptionKeyID = "encryption-key-id"
+
+	encryptionMagic     = "TCBE"
+	encryptionVersion   = 1
+	encryptionChunkSize = 64 << 10
+	saltSize            = 32
+	noncePrefixSize     = 7
+	// infoSize is the size of the header fields preceding the salt, which
+	// are bound to the derived key.
+	infoSize   = len(encryptionMagic) + 1 + 4
+	headerSize = infoSize + saltSize + noncePrefixSize
+)
+
+// ErrAuthentication is returned when an encrypted chunk fails GCM
+// authentication, i.e. the object was corrupted, tampered with or encrypted
+// with another key.
+var ErrAuthentication = errors.New("encrypted object failed authentication")
+
+// EncryptionKey is an AES-256 key along with the ID recorded in the metadata
+// of the objects it encrypts.
+type EncryptionKey struct {
+	ID  string
+	key []byte
+}
+
+// NewEncryptionKey wraps a raw 32-byte key. Its ID is derived from a SHA-256
+// fingerprint of the key so that the same key always gets the same ID.
+func NewEncryptionKey(key []byte) (*EncryptionKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid encryption key: need 32 bytes, got %d", len(key))
+	}
+	sum := sha256.Sum256(key)
+	return &EncryptionKey{ID: hex.EncodeToString(sum[:8]), key: key}, nil
+}
+
+// LoadEncryptionKey reads a key from path, or from the EncryptionKeyEnv
+// environment variable if path is empty. It returns nil if neither is set.
+// The key may be stored raw or hex or base64 encoded.
+func LoadEncryptionKey(path string) (*EncryptionKey, error) {
+	var data []byte
+	if path != "" {
+		var err error
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	} else if env := os.Getenv(EncryptionKeyEnv); env != "" {
+		data = []byte(env)
+	} else {
+		return nil, nil
+	}
+	if len(data) == 32 {
+		return NewEncryptionKey(data)
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return NewEncryptionKey(key)
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return NewEncryptionKey(key)
+	}
+	return nil, errors.New("invalid encryption key: need 32 bytes, raw or hex or base64 encoded")
+}
+
+// Keyring holds the keys available for decryption, indexed by key ID.
+type Keyring map[string]*EncryptionKey
+
+// LoadKeyring loads every key file in paths plus the key from the
+// EncryptionKeyEnv environment variable, if set.
+func LoadKeyring(paths []string) (Keyring, error) {
+	kr := make(Keyring)
+	for _, path := range append(paths, "") {
+		key, err := LoadEncryptionKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			kr[key.ID] = key
+		}
+	}
+	return kr, nil
+}
+
+// metadata returns the object metadata recorded for objects encrypted with k.
+func (k *EncryptionKey) metadata() map[string]string {
+	return map[string]string{
+		metaEncryption:      EncryptionAlgorithm,
+		metaEncryptionKeyID: k.ID,
+	}
+}
+
+// decrypter returns a reader decrypting r according to the object metadata
+// md, or r itself if the object is not encrypted.
+func (kr Keyring) decrypter(r io.Reader, md map[string]string) (io.Reader, error) {
+	algorithm, ok := md[metaEncryption]
+	if !ok {
+		return r, nil
+	}
+	if algorithm != EncryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm: %s", algorithm)
+	}
+	id := md[metaEncryptionKeyID]
+	key, ok := kr[id]
+	if !ok {
+		return nil, fmt.Errorf("object is encrypted with key %s which was not provided", id)
+	}
+	return NewDecryptReader(r, key)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey derives the key of a single object from the encryption key and
+// the salt of the object with HKDF-SHA256 (RFC 5869). A single block of output
+// is needed for an AES-256 key, so the expand step is one HMAC.
+func deriveKey(key, salt, info []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(key)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = append(nonce, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	out     []byte
+}
+
+// NewEncryptWriter returns a writer encrypting everything written to it into
+// w. Close must be called to seal the final chunk; it does not close w.
+func NewEncryptWriter(w io.Writer, key *EncryptionKey) (io.WriteCloser, error) {
+	header := make([]byte, headerSize)
+	copy(header, encryptionMagic)
+	header[len(encryptionMagic)] = encryptionVersion
+	binary.BigEndian.PutUint32(header[len(encryptionMagic)+1:], encryptionChunkSize)
+	// The salt and the nonce prefix are drawn together.
+	if _, err := io.ReadFull(rand.Reader, header[infoSize:]); err != nil {
+		return nil, err
+	}
+	salt := header[infoSize : infoSize+saltSize]
+	prefix := header[headerSize-noncePrefixSize:]
+	aead, err := newGCM(deriveKey(key.key, salt, header[:infoSize]))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptionChunkSize),
+		out:    make([]byte, 0, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, since the
+		// last chunk has to be sealed differently by Close.
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	e.out = e.aead.Seal(e.out[:0], chunkNonce(e.prefix, e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	in      []byte
+	buf     []byte
+	done    bool
+}
+
+// NewDecryptReader returns a reader decrypting the output of an encrypt
+// writer read from r. Reads fail with ErrAuthentication if the content does
+// not authenticate with key.
+func NewDecryptReader(r io.Reader, key *EncryptionKey) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header[:infoSize]); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %v", err)
+	}
+	if !bytes.Equal(header[:len(encryptionMagic)], []byte(encryptionMagic)) {
+		return nil, errors.New("invalid encryption header")
+	}
+	chunkSize := binary.BigEndian.Uint32(header[len(encryptionMagic)+1:])
+	if chunkSize == 0 || chunkSize > 16<<20 {
+		return nil, fmt.Errorf("invalid encryption chunk size: %d", chunkSize)
+	}
+	if version := header[len(encryptionMagic)]; version != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encryption version: %d", version)
+	}
+	if _, err := io.ReadFull(r, header[infoSize:]); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %v", err)
+	}
+	aead, err := newGCM(deriveKey(key.key, header[infoSize:infoSize+saltSize], header[:infoSize]))
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		prefix: header[len(header)-noncePrefixSize:],
+		in:     make([]byte, int(chunkSize)+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.in)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			// The last chunk is never empty, so the stream was truncated.
+			return ErrAuthentication
+		}
+		return err
+	}
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, err = d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	plain, err := d.aead.Open(d.in[:0], chunkNonce(d.prefix, d.counter, last), d.in[:n], nil)
+	if err != nil {
+		return ErrAuthentication
+	}
+	d.counter++
+	d.buf = plain
+	d.done = last
+	return nil
+}
@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+)
+
+func newTestKey(t *testing.T) *EncryptionKey {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewEncryptionKey(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encrypt(t *testing.T, key *EncryptionKey, plain []byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(key *EncryptionKey, sealed []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(sealed), key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// sealedChunkSize is the size of a full chunk once sealed.
+const sealedChunkSize = encryptionChunkSize + 16
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := newTestKey(t)
+	for _, tc := range []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"partial chunk", encryptionChunkSize - 1},
+		{"one chunk", encryptionChunkSize},
+		{"chunk and a byte", encryptionChunkSize + 1},
+		{"several chunks", 3*encryptionChunkSize + 12345},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plain := randomBytes(t, tc.size)
+			sealed := encrypt(t, key, plain)
+			if sealed[len(encryptionMagic)] != encryptionVersion {
+				t.Fatalf("version = %d, want %d", sealed[len(encryptionMagic)], encryptionVersion)
+			}
+			got, err := decrypt(key, sealed)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("decrypted %d bytes not matching the %d bytes encrypted", len(got), len(plain))
+			}
+		})
+	}
+}
+
+func TestEncryptSaltsObjects(t *testing.T) {
+	key := newTestKey(t)
+	plain := randomBytes(t, 100)
+	a, b := encrypt(t, key, plain), encrypt(t, key, plain)
+	if bytes.Equal(a[infoSize:infoSize+saltSize], b[infoSize:infoSize+saltSize]) {
+		t.Fatal("two objects got the same salt")
+	}
+	if bytes.Equal(a[headerSize:], b[headerSize:]) {
+		t.Fatal("two objects got the same ciphertext")
+	}
+}
+
+func TestDecryptTruncated(t *testing.T) {
+	key := newTestKey(t)
+	sealed := encrypt(t, key, randomBytes(t, 3*encryptionChunkSize))
+	for _, tc := range []struct {
+		name string
+		size int
+	}{
+		{"header only", headerSize},
+		{"first chunk", headerSize + sealedChunkSize},
+		{"second chunk", headerSize + 2*sealedChunkSize},
+		{"within chunk", headerSize + sealedChunkSize + 100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := decrypt(key, sealed[:tc.size]); err != ErrAuthentication {
+				t.Fatalf("decrypt = %v, want %v", err, ErrAuthentication)
+			}
+		})
+	}
+}
+
+func TestDecryptReorderedChunks(t *testing.T) {
+	key := newTestKey(t)
+	sealed := encrypt(t, key, randomBytes(t, 3*encryptionChunkSize))
+	first := sealed[headerSize : headerSize+sealedChunkSize]
+	second := sealed[headerSize+sealedChunkSize : headerSize+2*sealedChunkSize]
+	var reordered []byte
+	reordered = append(reordered, sealed[:headerSize]...)
+	reordered = append(reordered, second...)
+	reordered = append(reordered, first...)
+	reordered = append(reordered, sealed[headerSize+2*sealedChunkSize:]...)
+	if _, err := decrypt(key, reordered); err != ErrAuthentication {
+		t.Fatalf("decrypt = %v, want %v", err, ErrAuthentication)
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	for _, size := range []int{0, 1000} {
+		sealed := encrypt(t, newTestKey(t), randomBytes(t, size))
+		if _, err := decrypt(newTestKey(t), sealed); err != ErrAuthentication {
+			t.Fatalf("decrypt %d bytes = %v, want %v", size, err, ErrAuthentication)
+		}
+	}
+}
+
+func TestDecryptTamperedSalt(t *testing.T) {
+	key := newTestKey(t)
+	sealed := encrypt(t, key, randomBytes(t, 1000))
+	sealed[infoSize] ^= 1
+	if _, err := decrypt(key, sealed); err != ErrAuthentication {
+		t.Fatalf("decrypt = %v, want %v", err, ErrAuthentication)
+	}
+}
+
+func TestDecryptUnsupportedVersion(t *testing.T) {
+	key := newTestKey(t)
+	sealed := encrypt(t, key, randomBytes(t, 100))
+	sealed[len(encryptionMagic)]++
+	if _, err := decrypt(key, sealed); err == nil || err == ErrAuthentication {
+		t.Fatalf("decrypt = %v, want an unsupported version error", err)
+	}
+}
@@ -0,0 +1,443 @@
+package pkg
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gocloud.dev/blob"
+)
+
+// DownloadOptions controls how objects are fetched from a bucket.
+type DownloadOptions struct {
+	// Concurrency is the number of objects downloaded in parallel.
+	Concurrency int
+	// Retries is the number of times an object is downloaded again after its
+	// content did not match the manifest checksum.
+	Retries int
+	// AllowIncomplete downloads a backup even if its manifest is missing or
+	// does not match the objects in the bucket. Objects failing their
+	// checksum are then reported without stopping the other downloads.
+	AllowIncomplete bool
+	// Keyring holds the keys used to decrypt encrypted objects.
+	Keyring Keyring
+	// Filter selects the objects downloaded by their key relative to the
+	// backup, all of them if nil.
+	Filter *Filter
+}
+
+// Downloader copies the objects below a prefix to a local directory.
+type Downloader struct {
+	bucket *blob.Bucket
+	opts   DownloadOptions
+}
+
+// NewDownloader creates a Downloader reading from b.
+func NewDownloader(b *blob.Bucket, opts DownloadOptions) *Downloader {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	return &Downloader{
+		bucket: b,
+		opts:   opts,
+	}
+}
+
+// Download fetches every object below srcDir into destDir, recreating the
+// key layout relative to srcDir. Objects are handed to a pool of Concurrency
+// workers as the listing proceeds; the first failure cancels the remaining
+// work and all failed keys are returned as TransferErrors.
+//
+// Backups that are not complete according to CheckComplete are refused with
+// an *IncompleteError unless AllowIncomplete is set, in which case objects
+// failing their checksum do not cancel the others. Objects listed in the
+// manifest are checked against their recorded checksum, and objects it does
+// not list are skipped; only a backup without a manifest is downloaded
+// unchecked. Objects not selected by Filter are skipped, and keys that would
+// be written outside destDir are refused.
+//
+// Files are written under a hidden temporary name, see partialPath, and
+// renamed into place once complete and verified. Files left in destDir by a
+// previous attempt are skipped when they match their object, or for objects
+// stored compressed or encrypted the file they were uploaded from. Temporary
+// files are resumed when there is a checksum to verify them with, unless the
+// object is compressed or encrypted. The temporary file of an object is
+// removed once it is in place.
+func (d *Downloader) Download(ctx context.Context, srcDir, destDir string) error {
+	manifest, err := CheckComplete(ctx, d.bucket, srcDir)
+	if err != nil {
+		if _, ok := err.(*IncompleteError); !ok || !d.opts.AllowIncomplete {
+			return err
+		}
+		log.Println(fmt.Sprintf("Downloading incomplete backup: %v", err))
+	}
+	if err = os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	var (
+		algorithm string
+		objs      map[string]ManifestObject
+	)
+	if manifest != nil {
+		algorithm = manifest.ChecksumAlgorithm
+		objs = manifest.ObjectsByKey()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		keys = make(chan string)
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs TransferErrors
+	)
+	// List with a trailing slash so that e.g. "backup-1" does not also match
+	// "backup-10", and strip it again so the tree is recreated directly under
+	// destDir.
+	prefix := strings.TrimSuffix(srcDir, "/") + "/"
+	for i := 0; i < d.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				log.Println(fmt.Sprintf("Begin download file: %s", key))
+				path, err := localPath(destDir, prefix, key)
+				if err == nil {
+					err = d.downloadFile(ctx, key, path, algorithm, objs[key])
+				}
+				if err != nil {
+					// Once the first failure cancelled the context, the
+					// other in-flight downloads fail with it; only
+					// report the root causes.
+					mu.Lock()
+					aborted := len(errs) > 0 && ctx.Err() != nil
+					if !aborted {
+						errs = append(errs, &FileError{Name: key, Err: err})
+					}
+					mu.Unlock()
+					if !aborted {
+						log.Println(fmt.Sprintf("Download file: %s failed, error: %v", key, err))
+						// A forensic download of a damaged backup
+						// fetches whatever else can be verified.
+						if _, ok := err.(*ChecksumError); !ok || !d.opts.AllowIncomplete {
+							cancel()
+						}
+					}
+					continue
+				}
+				log.Println(fmt.Sprintf("Download file: %s successfully", key))
+			}
+		}()
+	}
+
+	err = d.list(ctx, prefix, objs, keys)
+	close(keys)
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs
+	}
+	return err
+}
+
+// list feeds the keys below prefix into keys until the listing is exhausted
+// or ctx is cancelled. Unless objs is nil, only the keys it holds are fed.
+func (d *Downloader) list(ctx context.Context, prefix string, objs map[string]ManifestObject, keys chan<- string) error {
+	iter := d.bucket.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if obj.Key == ManifestKey(prefix) {
+			continue
+		}
+		if _, ok := objs[obj.Key]; objs != nil && !ok {
+			log.Println(fmt.Sprintf("Skip file: %s not in the manifest", obj.Key))
+			continue
+		}
+		if !d.opts.Filter.Match(strings.TrimPrefix(obj.Key, prefix)) {
+			log.Println(fmt.Sprintf("Skip file: %s excluded by filter", obj.Key))
+			continue
+		}
+		select {
+		case keys <- obj.Key:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// localPath returns the path below destDir the object key is downloaded to,
+// recreating its key relative to prefix. Keys that would resolve outside
+// destDir, such as "backup/../../file", are refused.
+func localPath(destDir, prefix, key string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(key, prefix)))
+	if filepath.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("object key %s resolves outside of the download directory", key)
+	}
+	return filepath.Join(destDir, rel), nil
+}
+
+// downloadFile copies the object key into the local file at path,
+// decrypting and decompressing it as recorded in its metadata. If the
+// manifest entry obj has a checksum the stored content is hashed while
+// streaming and downloaded again up to Retries times when it does not match.
+func (d *Downloader) downloadFile(ctx context.Context, key, path, algorithm string, obj ManifestObject) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		err := d.copyObject(ctx, key, path, algorithm, obj)
+		if _, ok := err.(*ChecksumError); ok && attempt < d.opts.Retries {
+			log.Println(fmt.Sprintf("Retry download file: %s, error: %v", key, err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	// A file skipped as already downloaded may still have a temporary file
+	// left by an attempt interrupted after it.
+	if err := os.Remove(partialPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (d *Downloader) copyObject(ctx context.Context, key, path, algorithm string, obj ManifestObject) error {
+	attrs, err := d.bucket.Attributes(ctx, key)
+	if err != nil {
+		return err
+	}
+	var h hash.Hash
+	if obj.Checksum != "" {
+		if h, err = NewChecksum(algorithm); err != nil {
+			return err
+		}
+	}
+	_, compressed := attrs.Metadata[metaCompression]
+	_, encrypted := attrs.Metadata[metaEncryption]
+	if !compressed && !encrypted {
+		return d.copyPlain(ctx, key, path, attrs.Size, h, obj.Checksum)
+	}
+
+	// The local file is compared with the file the object was uploaded
+	// from, since the object itself cannot be resumed.
+	var source hash.Hash
+	if obj.SourceChecksum != "" {
+		if source, err = NewChecksum(algorithm); err != nil {
+			return err
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Size() == obj.SourceSize {
+			ok, err := fileMatches(path, source, obj.SourceChecksum)
+			if err != nil {
+				return err
+			}
+			if ok {
+				log.Println(fmt.Sprintf("Skip file: %s already downloaded", key))
+				return nil
+			}
+			source.Reset()
+		}
+	}
+
+	r, err := d.bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	stored := &countingReader{r: r}
+	var src io.Reader = stored
+	if h != nil {
+		src = io.TeeReader(stored, h)
+	}
+	if src, err = d.opts.Keyring.decrypter(src, attrs.Metadata); err != nil {
+		return err
+	}
+	dec, err := decompressor(src, attrs.Metadata)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	// The object is written under a temporary name and only renamed into
+	// place once complete and verified, so that a failed or interrupted
+	// download never leaves a truncated file for loader to import.
+	tmp := partialPath(path)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	var dst io.Writer = f
+	if source != nil {
+		dst = io.MultiWriter(f, source)
+	}
+	n, err := io.Copy(dst, dec)
+	if err == nil {
+		err = verifyDownload(key, stored.n, attrs.Size, h, obj.Checksum)
+	}
+	if err == nil && source != nil {
+		err = verifyDownload(key, n, obj.SourceSize, source, obj.SourceChecksum)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	return commitDownload(f, tmp, path)
+}
+
+// copyPlain copies an object stored as is, i.e. neither compressed nor
+// encrypted, into the local file at path. A local file is kept if it has the
+// size of the object and matches checksum, or the size alone when checksum is
+// empty. A temporary file left by an interrupted attempt is resumed with a
+// range read when there is a checksum to verify the result with, and started
+// again otherwise.
+func (d *Downloader) copyPlain(ctx context.Context, key, path string, size int64, h hash.Hash, checksum string) error {
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Size() == size {
+		if h == nil {
+			log.Println(fmt.Sprintf("Skip file: %s already downloaded", key))
+			return nil
+		}
+		ok, err := fileMatches(path, h, checksum)
+		if err != nil {
+			return err
+		}
+		if ok {
+			log.Println(fmt.Sprintf("Skip file: %s already downloaded", key))
+			return nil
+		}
+		h.Reset()
+	}
+
+	tmp := partialPath(path)
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	// Without a checksum nothing tells whether the part kept from a previous
+	// attempt still belongs to the object, so only resume verified content.
+	offset := info.Size()
+	if offset > size || h == nil {
+		offset = 0
+	}
+	if offset > 0 && h != nil {
+		if _, err = io.CopyN(h, f, offset); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = f.Truncate(offset); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	n := offset
+	if offset < size {
+		if offset > 0 {
+			log.Println(fmt.Sprintf("Resume download file: %s from offset %d", key, offset))
+		}
+		r, err := d.bucket.NewRangeReader(ctx, key, offset, -1, nil)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		defer r.Close()
+		var dst io.Writer = f
+		if h != nil {
+			dst = io.MultiWriter(f, h)
+		}
+		// A failed copy keeps the temporary file, to be resumed by the
+		// next attempt.
+		copied, err := io.Copy(dst, r)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		n += copied
+	}
+	if err = verifyDownload(key, n, size, h, checksum); err != nil {
+		// The mismatch may come from the part kept from a previous
+		// attempt, so start again from scratch.
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	return commitDownload(f, tmp, path)
+}
+
+// partialPath returns the temporary file the object downloaded to path is
+// written to. It is hidden and has a suffix of its own, so that it neither
+// collides with a file of the backup nor is picked up by loader.
+func partialPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tcb-partial")
+}
+
+// verifyDownload checks the number of bytes read from the object key and the
+// checksum of its content when h is set.
+func verifyDownload(key string, n, size int64, h hash.Hash, checksum string) error {
+	if n != size {
+		return fmt.Errorf("short download of %s: got %d of %d bytes", key, n, size)
+	}
+	if h != nil {
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != checksum {
+			return &ChecksumError{Key: key, Expected: checksum, Actual: actual}
+		}
+	}
+	return nil
+}
+
+// commitDownload closes the temporary file f and renames it to path.
+func commitDownload(f *os.File, tmp, path string) error {
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// fileMatches reports whether the content of the local file at path hashes to
+// checksum with h.
+func fileMatches(path string, h hash.Hash, checksum string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err = io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == checksum, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	dest := filepath.FromSlash("/restore/dest")
+	for _, tc := range []struct {
+		key  string
+		want string
+	}{
+		{"backup/db.t.sql", "/restore/dest/db.t.sql"},
+		{"backup/sub/db.t.sql", "/restore/dest/sub/db.t.sql"},
+		{"backup/sub/../db.t.sql", "/restore/dest/db.t.sql"},
+		{"backup/./db.t.sql", "/restore/dest/db.t.sql"},
+		{"backup/..file", "/restore/dest/..file"},
+		{"backup/../escaped.txt", ""},
+		{"backup/sub/../../escaped.txt", ""},
+		{"backup/..", ""},
+		{"backup/", ""},
+		{"backup/.", ""},
+	} {
+		got, err := localPath(dest, "backup/", tc.key)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("localPath(%q) = %q, want an error", tc.key, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("localPath(%q): %v", tc.key, err)
+		} else if want := filepath.FromSlash(tc.want); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", tc.key, got, want)
+		}
+	}
+}
+
+// uploadTestBackup uploads files as the backup "backup" of a bucket of the
+// file provider below dir, stored as is.
+func uploadTestBackup(t *testing.T, dir string, files map[string][]byte) *Downloader {
+	src := filepath.Join(dir, "src")
+	writeFiles(t, src, files)
+	b := newFileBucket(t, filepath.Join(dir, "store"))
+	if _, err := NewUploader(b, UploadOptions{}).UploadDir(context.Background(), src, "backup"); err != nil {
+		t.Fatal(err)
+	}
+	return NewDownloader(b, DownloadOptions{})
+}
+
+func TestDownloadResumesPartialFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	files := map[string][]byte{"a.sql": randomBytes(t, 100000), "sub/b.sql": randomBytes(t, 1000)}
+	d := uploadTestBackup(t, dir, files)
+	dest := filepath.Join(dir, "dest")
+	writeFiles(t, dest, map[string][]byte{
+		".a.sql.tcb-partial":     files["a.sql"][:40000],
+		"sub/.b.sql.tcb-partial": files["sub/b.sql"],
+	})
+
+	if err := d.Download(context.Background(), "backup", dest); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got := readFiles(t, dest); !reflect.DeepEqual(got, files) {
+		t.Fatalf("downloaded %d files not matching the %d uploaded, or partial files left", len(got), len(files))
+	}
+}
+
+func TestDownloadCorruptPartialFile(t *testing.T) {
+	ctx := context.Background()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	data := randomBytes(t, 100000)
+	d := uploadTestBackup(t, dir, map[string][]byte{"a.sql": data})
+	dest := filepath.Join(dir, "dest")
+	path, partial := filepath.Join(dest, "a.sql"), filepath.Join(dest, ".a.sql.tcb-partial")
+	corrupt := append([]byte(nil), data[:40000]...)
+	corrupt[100] ^= 1
+	writeFiles(t, dest, map[string][]byte{".a.sql.tcb-partial": corrupt})
+
+	// The resumed content fails its checksum, and the partial file is
+	// dropped so that the next attempt starts from scratch.
+	err := d.Download(ctx, "backup", dest)
+	if errs, ok := err.(TransferErrors); !ok || len(errs) != 1 {
+		t.Fatalf("Download = %v, want a single failure", err)
+	} else if _, ok := errs[0].Err.(*ChecksumError); !ok {
+		t.Fatalf("Download = %v, want a checksum error", err)
+	}
+	for _, p := range []string{path, partial} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s left after the failed download: %v", p, err)
+		}
+	}
+
+	if err = d.Download(ctx, "backup", dest); err != nil {
+		t.Fatalf("Download again: %v", err)
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file does not match: %v", err)
+	}
+
+	// With retries the second attempt already succeeds.
+	os.Remove(path)
+	writeFiles(t, dest, map[string][]byte{".a.sql.tcb-partial": corrupt})
+	d.opts.Retries = 1
+	if err = d.Download(ctx, "backup", dest); err != nil {
+		t.Fatalf("Download with retries: %v", err)
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file does not match: %v", err)
+	}
+}
+
+func TestDownloadReplacesLocalFiles(t *testing.T) {
+	files := map[string][]byte{"a.sql": randomBytes(t, 10000), "b.sql": randomBytes(t, 10000)}
+	for _, tc := range []struct {
+		name  string
+		local map[string][]byte
+	}{
+		{"file of the right size but wrong content", map[string][]byte{"a.sql": randomBytes(t, 10000)}},
+		{"partial file longer than the object", map[string][]byte{".a.sql.tcb-partial": randomBytes(t, 20000)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+			d := uploadTestBackup(t, dir, files)
+			dest := filepath.Join(dir, "dest")
+			writeFiles(t, dest, tc.local)
+			// The file of the right size is hashed, and the partial
+			// file longer than the object is not resumed.
+			if err := d.Download(context.Background(), "backup", dest); err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if got := readFiles(t, dest); !reflect.DeepEqual(got, files) {
+				t.Fatal("downloaded files do not match the uploaded ones")
+			}
+		})
+	}
+}
+
+func TestDownloadRestartsUnverifiedPartialFile(t *testing.T) {
+	ctx := context.Background()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	data := randomBytes(t, 10000)
+	d := uploadTestBackup(t, dir, map[string][]byte{"a.sql": data})
+	// Without a manifest nothing verifies a resumed partial file.
+	if err := d.bucket.Delete(ctx, ManifestKey("backup")); err != nil {
+		t.Fatal(err)
+	}
+	d.opts.AllowIncomplete = true
+	dest := filepath.Join(dir, "dest")
+	writeFiles(t, dest, map[string][]byte{".a.sql.tcb-partial": randomBytes(t, 4000)})
+
+	if err := d.Download(ctx, "backup", dest); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got := readFiles(t, dest); !reflect.DeepEqual(got, map[string][]byte{"a.sql": data}) {
+		t.Fatal("downloaded file does not match the uploaded one")
+	}
+}
@@ -0,0 +1,29 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FileError records the failure to transfer a single file or object.
+type FileError struct {
+	Name string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+// TransferErrors collects the per-file failures of a bulk transfer.
+type TransferErrors []*FileError
+
+func (e TransferErrors) Error() string {
+	sort.Slice(e, func(i, j int) bool { return e[i].Name < e[j].Name })
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("%d file(s) failed:\n  %s", len(e), strings.Join(msgs, "\n  "))
+}
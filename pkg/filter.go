@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter selects the files of a backup by the database and table they hold,
+// as named by mydumper, or by their key relative to the backup.
+//
+// Patterns without a slash are globs over database and table names: "db.table"
+// matches the files of the tables matching table in the databases matching db,
+// and "db" is short for "db.*". They only apply to the files named after a
+// database or table, such as db.table.sql, db.table.00001.sql,
+// db.table-schema.sql and db-schema-create.sql. The schema file of a database
+// is kept along with any of its tables, and only excluded with the whole
+// database.
+//
+// Patterns with a slash are globs over the key of a file relative to the
+// backup, with an optional leading slash, e.g. "/metadata" or "/*.sql.gz", and
+// apply to every file.
+//
+// A file is selected if it matches an include pattern, or if no include
+// pattern applies to it, and matches no exclude pattern.
+type Filter struct {
+	include []pattern
+	exclude []pattern
+}
+
+// pattern is a parsed filter pattern: a key glob, or database and table globs.
+type pattern struct {
+	s     string
+	key   string
+	db    string
+	table string
+}
+
+// NewFilter parses the include and exclude patterns of a Filter.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	for _, s := range include {
+		p, err := parsePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, p)
+	}
+	for _, s := range exclude {
+		p, err := parsePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, p)
+	}
+	return f, nil
+}
+
+func parsePattern(s string) (pattern, error) {
+	p := pattern{s: s}
+	if strings.Contains(s, "/") {
+		p.key = strings.TrimPrefix(s, "/")
+	} else if i := strings.Index(s, "."); i >= 0 {
+		p.db, p.table = s[:i], s[i+1:]
+	} else {
+		p.db, p.table = s, "*"
+	}
+	for _, glob := range []string{p.key, p.db, p.table} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return pattern{}, fmt.Errorf("invalid filter pattern %q: %v", s, err)
+		}
+	}
+	if p.key == "" && (p.db == "" || p.table == "") {
+		return pattern{}, fmt.Errorf("invalid filter pattern %q", s)
+	}
+	return p, nil
+}
+
+// manifest returns the patterns of f as recorded in the manifest of the backup
+// it filtered, or nil if f selects every file.
+func (f *Filter) manifest() *ManifestFilter {
+	if f == nil || len(f.include) == 0 && len(f.exclude) == 0 {
+		return nil
+	}
+	mf := &ManifestFilter{}
+	for _, p := range f.include {
+		mf.Include = append(mf.Include, p.s)
+	}
+	for _, p := range f.exclude {
+		mf.Exclude = append(mf.Exclude, p.s)
+	}
+	return mf
+}
+
+// Match reports whether the file at rel, its slash separated key relative to
+// the backup, is selected. A nil Filter selects every file.
+func (f *Filter) Match(rel string) bool {
+	if f == nil {
+		return true
+	}
+	db, table, named := parseDumpFileName(path.Base(rel))
+	included, applies := false, false
+	for _, p := range f.include {
+		if p.key == "" && !named {
+			continue
+		}
+		applies = true
+		if p.match(rel, db, table, true) {
+			included = true
+			break
+		}
+	}
+	if applies && !included {
+		return false
+	}
+	for _, p := range f.exclude {
+		if (p.key != "" || named) && p.match(rel, db, table, false) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p pattern) match(rel, db, table string, include bool) bool {
+	if p.key != "" {
+		ok, _ := path.Match(p.key, rel)
+		return ok
+	}
+	if ok, _ := path.Match(p.db, db); !ok {
+		return false
+	}
+	if table == "" {
+		// A database schema file is needed by any of its tables.
+		return include || p.table == "*"
+	}
+	ok, _ := path.Match(p.table, table)
+	return ok
+}
+
+// dumpSchemaSuffixes are the suffixes mydumper appends to the names of schema
+// files, from the most specific.
+var dumpSchemaSuffixes = []string{"-schema-create", "-schema-post", "-schema-triggers", "-schema-view", "-schema"}
+
+// parseDumpFileName returns the database and table a mydumper file is named
+// after, with an empty table for database schema files such as
+// db-schema-create.sql. It reports false for the other files, such as the
+// metadata file.
+func parseDumpFileName(name string) (db, table string, ok bool) {
+	for _, ext := range []string{".gz", ".zst"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	if !strings.HasSuffix(name, ".sql") {
+		return "", "", false
+	}
+	name = strings.TrimSuffix(name, ".sql")
+	schema := ""
+	for _, suffix := range dumpSchemaSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			name, schema = strings.TrimSuffix(name, suffix), suffix
+			break
+		}
+	}
+	i := strings.Index(name, ".")
+	if i < 0 {
+		// Only databases have schema files without a table.
+		if name == "" || (schema != "-schema-create" && schema != "-schema-post") {
+			return "", "", false
+		}
+		return name, "", true
+	}
+	db, table = name[:i], name[i+1:]
+	if schema == "" {
+		// Data files of large tables are split into numbered chunks.
+		if j := strings.LastIndex(table, "."); j >= 0 && isDigits(table[j+1:]) {
+			table = table[:j]
+		}
+	}
+	if db == "" || table == "" {
+		return "", "", false
+	}
+	return db, table, true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDumpFileName(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		db    string
+		table string
+		ok    bool
+	}{
+		{"shop.orders.sql", "shop", "orders", true},
+		{"shop.orders.00001.sql", "shop", "orders", true},
+		{"shop.orders.00001.sql.gz", "shop", "orders", true},
+		{"shop.orders.sql.zst", "shop", "orders", true},
+		{"shop.orders-schema.sql", "shop", "orders", true},
+		{"shop.orders-schema.sql.gz", "shop", "orders", true},
+		{"shop.orders-schema-triggers.sql", "shop", "orders", true},
+		{"shop.orders_view-schema-view.sql", "shop", "orders_view", true},
+		{"shop-schema-create.sql", "shop", "", true},
+		{"shop-schema-create.sql.zst", "shop", "", true},
+		{"shop-schema-post.sql", "shop", "", true},
+		// Only data files are split into chunks.
+		{"shop.orders.2020-schema.sql", "shop", "orders.2020", true},
+		{"shop.orders.v2.sql", "shop", "orders.v2", true},
+		{"shop.orders.1.2.sql", "shop", "orders.1", true},
+		{"shop.sql", "", "", false},
+		{"shop-schema.sql", "", "", false},
+		{"-schema-create.sql", "", "", false},
+		{".orders.sql", "", "", false},
+		{"shop..sql", "", "", false},
+		{"shop.00001.sql", "shop", "00001", true},
+		{"metadata", "", "", false},
+		{"shop.orders.sql.bak", "", "", false},
+		{"shop.orders.gz", "", "", false},
+	} {
+		db, table, ok := parseDumpFileName(tc.name)
+		if db != tc.db || table != tc.table || ok != tc.ok {
+			t.Errorf("parseDumpFileName(%q) = %q, %q, %v, want %q, %q, %v", tc.name, db, table, ok, tc.db, tc.table, tc.ok)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	files := []string{
+		"metadata",
+		"shop-schema-create.sql",
+		"shop.orders-schema.sql",
+		"shop.orders.00001.sql.gz",
+		"shop.orders.00002.sql.gz",
+		"shop.users.sql",
+		"blog-schema-create.sql",
+		"blog.posts.sql.zst",
+		"sub/shop.orders.sql",
+	}
+	for _, tc := range []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "no patterns",
+			want: files,
+		},
+		{
+			name:    "include table keeps db schema",
+			include: []string{"shop.orders"},
+			want: []string{
+				"metadata",
+				"shop-schema-create.sql",
+				"shop.orders-schema.sql",
+				"shop.orders.00001.sql.gz",
+				"shop.orders.00002.sql.gz",
+				"sub/shop.orders.sql",
+			},
+		},
+		{
+			name:    "include database",
+			include: []string{"blog"},
+			want:    []string{"metadata", "blog-schema-create.sql", "blog.posts.sql.zst"},
+		},
+		{
+			name:    "include table glob",
+			include: []string{"*.posts"},
+			want:    []string{"metadata", "shop-schema-create.sql", "blog-schema-create.sql", "blog.posts.sql.zst"},
+		},
+		{
+			name:    "exclude table keeps db schema",
+			exclude: []string{"shop.orders"},
+			want: []string{
+				"metadata",
+				"shop-schema-create.sql",
+				"shop.users.sql",
+				"blog-schema-create.sql",
+				"blog.posts.sql.zst",
+			},
+		},
+		{
+			name:    "exclude database",
+			exclude: []string{"shop"},
+			want:    []string{"metadata", "blog-schema-create.sql", "blog.posts.sql.zst"},
+		},
+		{
+			name:    "exclude key with leading slash",
+			exclude: []string{"/metadata"},
+			want:    files[1:],
+		},
+		{
+			name:    "exclude key glob",
+			exclude: []string{"/*.gz"},
+			want: []string{
+				"metadata",
+				"shop-schema-create.sql",
+				"shop.orders-schema.sql",
+				"shop.users.sql",
+				"blog-schema-create.sql",
+				"blog.posts.sql.zst",
+				"sub/shop.orders.sql",
+			},
+		},
+		{
+			name:    "exclude key in directory",
+			exclude: []string{"sub/*"},
+			want:    files[:len(files)-1],
+		},
+		{
+			name:    "include key applies to every file",
+			include: []string{"/*-schema*.sql"},
+			want:    []string{"shop-schema-create.sql", "shop.orders-schema.sql", "blog-schema-create.sql"},
+		},
+		{
+			name:    "include and exclude",
+			include: []string{"shop"},
+			exclude: []string{"shop.orders", "/metadata"},
+			want:    []string{"shop-schema-create.sql", "shop.users.sql"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewFilter(tc.include, tc.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, name := range files {
+				if f.Match(name) {
+					got = append(got, name)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("selected %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewFilterInvalid(t *testing.T) {
+	for _, s := range []string{"[", "shop.", ".orders", "/["} {
+		if _, err := NewFilter([]string{s}, nil); err == nil {
+			t.Errorf("NewFilter(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestFilterManifest(t *testing.T) {
+	var nilFilter *Filter
+	if mf := nilFilter.manifest(); mf != nil {
+		t.Fatalf("manifest of nil filter = %+v, want nil", mf)
+	}
+	f, err := NewFilter([]string{"shop", "/metadata"}, []string{"shop.orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ManifestFilter{Include: []string{"shop", "/metadata"}, Exclude: []string{"shop.orders"}}
+	if mf := f.manifest(); !reflect.DeepEqual(mf, want) {
+		t.Fatalf("manifest = %+v, want %+v", mf, want)
+	}
+}
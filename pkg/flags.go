@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"context"
+	"flag"
+	"strconv"
+	"strings"
+
+	"gocloud.dev/blob"
+)
+
+// StorageFlags holds the command line flags selecting the bucket backups are
+// stored in, shared by every command.
+type StorageFlags struct {
+	Cloud   string
+	Bucket  string
+	Options BucketOptions
+}
+
+// RegisterStorageFlags defines the storage flags on fs.
+func RegisterStorageFlags(fs *flag.FlagSet) *StorageFlags {
+	f := &StorageFlags{}
+	fs.StringVar(&f.Cloud, "cloud", "", "Cloud storage to use: aws, gcp, ceph, s3, azure or file")
+	fs.StringVar(&f.Bucket, "bucket", "tidb-backup", "Name of bucket")
+	fs.StringVar(&f.Options.Endpoint, "endpoint", "", "Endpoint of S3-compatible or Azure object store, or root directory for the file provider")
+	fs.StringVar(&f.Options.S3.Region, "s3-region", "", "Region of the S3 bucket, looked up from the bucket if empty")
+	fs.Var(optionalBool{&f.Options.S3.ForcePathStyle}, "s3-path-style", "Use path-style instead of virtual-host-style S3 bucket addressing")
+	fs.Var(optionalBool{&f.Options.S3.TLS}, "s3-tls", "Connect to the S3 endpoint over https, set to false for plain http")
+	fs.StringVar(&f.Options.S3.CABundle, "s3-ca-bundle", "", "PEM file with additional CA certificates trusted for the S3 endpoint")
+	fs.Var(optionalInt{&f.Options.S3.MaxRetries}, "s3-max-retries", "`Number` of times a failed S3 request is retried")
+	fs.Var(optionalBool{&f.Options.S3.CreateBucket}, "s3-create-bucket", "Create the S3 bucket if it does not exist")
+	fs.Var(commaList{&f.Options.Credentials.AWSSources}, "aws-credential-sources", "Comma separated AWS credential `sources` tried in order: env, shared, web-identity and ec2 (default all)")
+	fs.StringVar(&f.Options.Credentials.AWSProfile, "aws-profile", "", "Profile of the AWS shared credentials file, defaults to $AWS_PROFILE")
+	fs.StringVar(&f.Options.Credentials.AWSSharedCredentialsFile, "aws-shared-credentials-file", "", "AWS shared credentials file, defaults to $AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials")
+	fs.StringVar(&f.Options.Credentials.AWSRoleARN, "aws-role-arn", "", "ARN of an AWS role assumed to access the bucket")
+	fs.StringVar(&f.Options.Credentials.AWSExternalID, "aws-external-id", "", "External ID passed when assuming the AWS role")
+	fs.StringVar(&f.Options.Credentials.AWSRoleSessionName, "aws-role-session-name", "", "Session name of assumed AWS roles")
+	fs.StringVar(&f.Options.Credentials.GCPCredentialsFile, "gcp-credentials-file", "", "GCP service account JSON key file, defaults to the application default credentials")
+	return f
+}
+
+// Setup opens the bucket selected by the flags.
+func (f *StorageFlags) Setup(ctx context.Context) (*blob.Bucket, error) {
+	return SetupBucket(ctx, f.Cloud, f.Bucket, &f.Options)
+}
+
+// optionalBool is a boolean flag which is left nil unless given, so that it
+// does not override presets.
+type optionalBool struct {
+	p **bool
+}
+
+func (b optionalBool) String() string {
+	if b.p == nil || *b.p == nil {
+		return ""
+	}
+	return strconv.FormatBool(**b.p)
+}
+
+func (b optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*b.p = &v
+	return nil
+}
+
+func (b optionalBool) IsBoolFlag() bool { return true }
+
+// optionalInt is an integer flag which is left nil unless given.
+type optionalInt struct {
+	p **int
+}
+
+func (i optionalInt) String() string {
+	if i.p == nil || *i.p == nil {
+		return ""
+	}
+	return strconv.Itoa(**i.p)
+}
+
+func (i optionalInt) Set(s string) error {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*i.p = &v
+	return nil
+}
+
+// commaList is a flag holding a comma separated list.
+type commaList struct {
+	p *[]string
+}
+
+func (l commaList) String() string {
+	if l.p == nil {
+		return ""
+	}
+	return strings.Join(*l.p, ",")
+}
+
+func (l commaList) Set(s string) error {
+	*l.p = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l.p = append(*l.p, v)
+		}
+	}
+	return nil
+}
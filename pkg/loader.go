@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Tools loading a dump into TiDB.
+const (
+	ToolLoader    = "loader"
+	ToolLightning = "tidb-lightning"
+)
+
+// LoadOptions configures a loader or tidb-lightning run.
+type LoadOptions struct {
+	// Tool is ToolLoader or ToolLightning.
+	Tool string
+	// Path of the tool binary.
+	Path     string
+	Host     string
+	Port     int
+	User     string
+	Password string
+	// Args are passed to the tool after the connection flags.
+	Args []string
+}
+
+// Load runs loader or tidb-lightning to load the dump in dir into TiDB. Its
+// output is logged as it runs and the end of it is included in the error if
+// the tool fails.
+func Load(ctx context.Context, dir string, opts LoadOptions) error {
+	var args []string
+	switch opts.Tool {
+	case ToolLoader:
+		args = []string{
+			"-d", dir,
+			"-h", opts.Host,
+			"-P", strconv.Itoa(opts.Port),
+			"-u", opts.User,
+		}
+		if opts.Password != "" {
+			args = append(args, "-p", opts.Password)
+		}
+	case ToolLightning:
+		args = []string{
+			"-d", dir,
+			"-tidb-host", opts.Host,
+			"-tidb-port", strconv.Itoa(opts.Port),
+			"-tidb-user", opts.User,
+		}
+		if opts.Password != "" {
+			args = append(args, "-tidb-password", opts.Password)
+		}
+	default:
+		return fmt.Errorf("invalid load tool: %s", opts.Tool)
+	}
+	return runTool(ctx, opts.Tool, opts.Path, append(args, opts.Args...))
+}
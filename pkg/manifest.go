@@ -0,0 +1,216 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// ManifestName is the name of the object describing a backup. It is written
+// under the backup prefix after every data object has been uploaded, so its
+// presence marks the backup as complete.
+const ManifestName = "manifest.json"
+
+// ManifestVersion is the version of the manifest format written by this
+// package.
+const ManifestVersion = 1
+
+// Manifest describes the content of a backup.
+type Manifest struct {
+	Version           int       `json:"version"`
+	Name              string    `json:"name"`
+	StartTime         time.Time `json:"start_time"`
+	FinishTime        time.Time `json:"finish_time"`
+	ChecksumAlgorithm string    `json:"checksum_algorithm"`
+	// Dump is the metadata of the mydumper dump the backup was uploaded
+	// from, if any.
+	Dump *DumpMetadata `json:"dump,omitempty"`
+	// Filter holds the patterns the backup was uploaded with, if any, in
+	// which case it does not hold every file of the dump.
+	Filter  *ManifestFilter  `json:"filter,omitempty"`
+	Objects []ManifestObject `json:"objects"`
+}
+
+// ManifestObject describes a single object of a backup.
+type ManifestObject struct {
+	Key      string `json:"key"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	// MD5 is the MD5 of the object as stored, compared with the MD5 reported
+	// by the provider to verify the object without reading it. It is empty
+	// in manifests written before it was recorded.
+	MD5 string `json:"md5,omitempty"`
+	// SourceSize and SourceChecksum describe the file the object was
+	// uploaded from, i.e. its content once decrypted and decompressed. They
+	// are only recorded for objects stored compressed or encrypted, so that
+	// a file already downloaded can be checked without downloading it again.
+	SourceSize     int64     `json:"source_size,omitempty"`
+	SourceChecksum string    `json:"source_checksum,omitempty"`
+	ModTime        time.Time `json:"mtime"`
+}
+
+// ManifestFilter records the patterns of the Filter a backup was uploaded with.
+type ManifestFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// ManifestKey returns the key of the manifest of the backup stored under
+// prefix.
+func ManifestKey(prefix string) string {
+	return path.Join(strings.TrimSuffix(prefix, "/"), ManifestName)
+}
+
+// TotalSize returns the sum of the sizes of all objects in the manifest.
+func (m *Manifest) TotalSize() int64 {
+	var size int64
+	for _, obj := range m.Objects {
+		size += obj.Size
+	}
+	return size
+}
+
+// WriteManifest stores m as the manifest of the backup under prefix. The dump
+// metadata is also recorded in the object metadata of the manifest, so that
+// it can be read without downloading the manifest.
+func WriteManifest(ctx context.Context, b *blob.Bucket, prefix string, m *Manifest) error {
+	sort.Slice(m.Objects, func(i, j int) bool { return m.Objects[i].Key < m.Objects[j].Key })
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	opts := &blob.WriterOptions{ContentType: "application/json"}
+	if m.Dump != nil {
+		opts.Metadata = m.Dump.metadata()
+	}
+	return b.WriteAll(ctx, ManifestKey(prefix), data, opts)
+}
+
+// ReadManifest loads the manifest of the backup under prefix. It returns a nil
+// manifest and no error if the backup has no manifest.
+func ReadManifest(ctx context.Context, b *blob.Bucket, prefix string) (*Manifest, error) {
+	data, err := b.ReadAll(ctx, ManifestKey(prefix))
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := new(Manifest)
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", ManifestKey(prefix), err)
+	}
+	return m, nil
+}
+
+// ReadManifestDump returns the dump metadata recorded in the object metadata
+// of the manifest of the backup under prefix, without downloading the
+// manifest. It returns nil and no error if the backup has no manifest or the
+// manifest records no dump metadata.
+func ReadManifestDump(ctx context.Context, b *blob.Bucket, prefix string) (*DumpMetadata, error) {
+	attrs, err := b.Attributes(ctx, ManifestKey(prefix))
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dumpMetadataFromAttributes(attrs.Metadata), nil
+}
+
+// ObjectsByKey indexes the manifest entries by object key.
+func (m *Manifest) ObjectsByKey() map[string]ManifestObject {
+	objs := make(map[string]ManifestObject, len(m.Objects))
+	for _, obj := range m.Objects {
+		objs[obj.Key] = obj
+	}
+	return objs
+}
+
+// IncompleteError reports a backup that cannot be trusted to be complete:
+// either its manifest is missing or some objects listed in it are missing or
+// have the wrong size.
+type IncompleteError struct {
+	Prefix       string
+	NoManifest   bool
+	Missing      []string
+	SizeMismatch []string
+}
+
+func (e *IncompleteError) Error() string {
+	if e.NoManifest {
+		return fmt.Sprintf("backup %s is incomplete: manifest %s not found", e.Prefix, ManifestKey(e.Prefix))
+	}
+	var problems []string
+	if len(e.Missing) > 0 {
+		problems = append(problems, fmt.Sprintf("%d missing object(s): %s", len(e.Missing), strings.Join(e.Missing, ", ")))
+	}
+	if len(e.SizeMismatch) > 0 {
+		problems = append(problems, fmt.Sprintf("%d object(s) with wrong size: %s", len(e.SizeMismatch), strings.Join(e.SizeMismatch, ", ")))
+	}
+	return fmt.Sprintf("backup %s is incomplete: %s", e.Prefix, strings.Join(problems, "; "))
+}
+
+// CheckComplete reads the manifest of the backup under prefix and checks that
+// every object it lists exists in the bucket with the recorded size. The
+// manifest is returned whenever it could be read, even if the check fails
+// with an *IncompleteError.
+func CheckComplete(ctx context.Context, b *blob.Bucket, prefix string) (*Manifest, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	m, err := ReadManifest(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, &IncompleteError{Prefix: prefix, NoManifest: true}
+	}
+	objs, err := listObjects(ctx, b, prefix)
+	if err != nil {
+		return m, err
+	}
+	if ie := m.check(prefix, objs); ie != nil {
+		return m, ie
+	}
+	return m, nil
+}
+
+// check compares the manifest with the objects found under prefix.
+func (m *Manifest) check(prefix string, objs map[string]*blob.ListObject) *IncompleteError {
+	ie := &IncompleteError{Prefix: prefix}
+	for _, obj := range m.Objects {
+		found, ok := objs[obj.Key]
+		if !ok {
+			ie.Missing = append(ie.Missing, obj.Key)
+		} else if found.Size != obj.Size {
+			ie.SizeMismatch = append(ie.SizeMismatch, obj.Key)
+		}
+	}
+	if len(ie.Missing) > 0 || len(ie.SizeMismatch) > 0 {
+		return ie
+	}
+	return nil
+}
+
+// listObjects returns the objects of the backup under prefix, by key.
+func listObjects(ctx context.Context, b *blob.Bucket, prefix string) (map[string]*blob.ListObject, error) {
+	objs := make(map[string]*blob.ListObject)
+	iter := b.List(&blob.ListOptions{Prefix: prefix + "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objs[obj.Key] = obj
+	}
+}
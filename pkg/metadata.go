@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MydumperMetadataName is the name of the file mydumper writes the metadata
+// of a dump to, at the root of the dump directory.
+const MydumperMetadataName = "metadata"
+
+// mydumperTimeFormat is the layout of the times in mydumper metadata files,
+// written in the local time of the dumping host.
+const mydumperTimeFormat = "2006-01-02 15:04:05"
+
+// Object metadata keys recording the dump metadata on the manifest object.
+const (
+	metaDumpStart  = "dump-start"
+	metaDumpFinish = "dump-finish"
+	metaBinlogFile = "binlog-file"
+	metaBinlogPos  = "binlog-pos"
+	metaBinlogGTID = "binlog-gtid"
+	metaTimeFormat = time.RFC3339
+)
+
+// DumpMetadata is the metadata mydumper records about a dump: when it ran and
+// the binlog position it is consistent with, where point-in-time recovery
+// starts replaying from.
+type DumpMetadata struct {
+	StartTime  time.Time `json:"start_time"`
+	FinishTime time.Time `json:"finish_time"`
+	BinlogFile string    `json:"binlog_file,omitempty"`
+	BinlogPos  uint64    `json:"binlog_pos,omitempty"`
+	GTID       string    `json:"gtid,omitempty"`
+}
+
+// ReadDumpMetadata parses the mydumper metadata file at path.
+func ReadDumpMetadata(path string) (*DumpMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseDumpMetadata(f)
+}
+
+// ParseDumpMetadata parses a mydumper metadata file, e.g.
+//
+//	Started dump at: 2019-03-25 11:29:58
+//	SHOW MASTER STATUS:
+//		Log: tidb-binlog
+//		Pos: 407037891616784385
+//		GTID:
+//
+//	Finished dump at: 2019-03-25 11:29:59
+//
+// Only the master status is kept; the slave status mydumper records when
+// dumping from a replica is ignored.
+func ParseDumpMetadata(r io.Reader) (*DumpMetadata, error) {
+	var (
+		d       DumpMetadata
+		err     error
+		section string
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			section = ""
+		case strings.HasPrefix(line, "Started dump at:"):
+			d.StartTime, err = parseMydumperTime(strings.TrimPrefix(line, "Started dump at:"))
+		case strings.HasPrefix(line, "Finished dump at:"):
+			d.FinishTime, err = parseMydumperTime(strings.TrimPrefix(line, "Finished dump at:"))
+		case strings.HasSuffix(line, "STATUS:"):
+			section = line
+		case section != "SHOW MASTER STATUS:":
+		case strings.HasPrefix(line, "Log:"):
+			d.BinlogFile = strings.TrimSpace(strings.TrimPrefix(line, "Log:"))
+		case strings.HasPrefix(line, "Pos:"):
+			d.BinlogPos, err = strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "Pos:")), 10, 64)
+		case strings.HasPrefix(line, "GTID:"):
+			d.GTID = strings.TrimSpace(strings.TrimPrefix(line, "GTID:"))
+		default:
+			// GTID sets of several sources span several lines.
+			d.GTID += line
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid mydumper metadata line %q: %v", line, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if d.StartTime.IsZero() {
+		return nil, fmt.Errorf("invalid mydumper metadata: no dump start time")
+	}
+	return &d, nil
+}
+
+func parseMydumperTime(s string) (time.Time, error) {
+	t, err := time.ParseInLocation(mydumperTimeFormat, strings.TrimSpace(s), time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// BinlogPosition describes the binlog position of the dump.
+func (d *DumpMetadata) BinlogPosition() string {
+	if d.BinlogFile == "" && d.GTID == "" {
+		return ""
+	}
+	pos := fmt.Sprintf("%s:%d", d.BinlogFile, d.BinlogPos)
+	if d.GTID != "" {
+		pos += " GTID " + d.GTID
+	}
+	return pos
+}
+
+// metadata returns the object metadata recording d.
+func (d *DumpMetadata) metadata() map[string]string {
+	md := map[string]string{
+		metaDumpStart: d.StartTime.Format(metaTimeFormat),
+	}
+	if !d.FinishTime.IsZero() {
+		md[metaDumpFinish] = d.FinishTime.Format(metaTimeFormat)
+	}
+	if d.BinlogFile != "" {
+		md[metaBinlogFile] = d.BinlogFile
+		md[metaBinlogPos] = strconv.FormatUint(d.BinlogPos, 10)
+	}
+	if d.GTID != "" {
+		md[metaBinlogGTID] = d.GTID
+	}
+	return md
+}
+
+// dumpMetadataFromAttributes returns the dump metadata recorded in the object
+// metadata md, or nil if there is none.
+func dumpMetadataFromAttributes(md map[string]string) *DumpMetadata {
+	start, err := time.Parse(metaTimeFormat, md[metaDumpStart])
+	if err != nil {
+		return nil
+	}
+	d := &DumpMetadata{
+		StartTime:  start,
+		BinlogFile: md[metaBinlogFile],
+		GTID:       md[metaBinlogGTID],
+	}
+	d.FinishTime, _ = time.Parse(metaTimeFormat, md[metaDumpFinish])
+	d.BinlogPos, _ = strconv.ParseUint(md[metaBinlogPos], 10, 64)
+	return d
+}
@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+func TestParseDumpMetadata(t *testing.T) {
+	start := time.Date(2019, 3, 25, 11, 29, 58, 0, time.Local).UTC()
+	finish := start.Add(time.Second)
+	for _, tc := range []struct {
+		name string
+		data string
+		want *DumpMetadata
+	}{
+		{
+			name: "tidb",
+			data: `Started dump at: 2019-03-25 11:29:58
+SHOW MASTER STATUS:
+	Log: tidb-binlog
+	Pos: 407037891616784385
+	GTID:
+
+Finished dump at: 2019-03-25 11:29:59
+`,
+			want: &DumpMetadata{StartTime: start, FinishTime: finish, BinlogFile: "tidb-binlog", BinlogPos: 407037891616784385},
+		},
+		{
+			name: "multi-line gtid",
+			data: `Started dump at: 2019-03-25 11:29:58
+SHOW MASTER STATUS:
+	Log: mysql-bin.000003
+	Pos: 194
+	GTID:3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,
+4e11fa47-71ca-11e1-9e33-c80aa9429562:1-3
+
+Finished dump at: 2019-03-25 11:29:59
+`,
+			want: &DumpMetadata{
+				StartTime:  start,
+				FinishTime: finish,
+				BinlogFile: "mysql-bin.000003",
+				BinlogPos:  194,
+				GTID:       "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5,4e11fa47-71ca-11e1-9e33-c80aa9429562:1-3",
+			},
+		},
+		{
+			name: "slave status ignored",
+			data: `Started dump at: 2019-03-25 11:29:58
+SHOW SLAVE STATUS:
+	Host: 10.0.0.1
+	Log: mysql-bin.000010
+	Pos: 1234
+	GTID:5e11fa47-71ca-11e1-9e33-c80aa9429562:1-9,
+6e11fa47-71ca-11e1-9e33-c80aa9429562:1-2
+
+SHOW MASTER STATUS:
+	Log: mysql-bin.000003
+	Pos: 194
+	GTID:
+
+Finished dump at: 2019-03-25 11:29:59
+`,
+			want: &DumpMetadata{StartTime: start, FinishTime: finish, BinlogFile: "mysql-bin.000003", BinlogPos: 194},
+		},
+		{
+			name: "unfinished",
+			data: "Started dump at: 2019-03-25 11:29:58\n",
+			want: &DumpMetadata{StartTime: start},
+		},
+		{
+			name: "no start time",
+			data: "SHOW MASTER STATUS:\n\tLog: mysql-bin.000003\n\tPos: 194\n",
+		},
+		{
+			name: "invalid time",
+			data: "Started dump at: 2019-03-25T11:29:58Z\n",
+		},
+		{
+			name: "invalid position",
+			data: "Started dump at: 2019-03-25 11:29:58\nSHOW MASTER STATUS:\n\tLog: mysql-bin.000003\n\tPos: -1\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := ParseDumpMetadata(strings.NewReader(tc.data))
+			if tc.want == nil {
+				if err == nil {
+					t.Fatalf("ParseDumpMetadata = %+v, want an error", d)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(d, tc.want) {
+				t.Fatalf("ParseDumpMetadata = %+v, want %+v", d, tc.want)
+			}
+		})
+	}
+}
+
+func TestDumpMetadataAttributes(t *testing.T) {
+	start := time.Date(2019, 3, 25, 11, 29, 58, 0, time.UTC)
+	for _, d := range []*DumpMetadata{
+		{StartTime: start},
+		{StartTime: start, FinishTime: start.Add(time.Second), BinlogFile: "tidb-binlog", BinlogPos: 407037891616784385},
+		{StartTime: start, BinlogFile: "mysql-bin.000003", BinlogPos: 194, GTID: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"},
+	} {
+		if got := dumpMetadataFromAttributes(d.metadata()); !reflect.DeepEqual(got, d) {
+			t.Errorf("dumpMetadataFromAttributes(%v) = %+v, want %+v", d.metadata(), got, d)
+		}
+	}
+	if d := dumpMetadataFromAttributes(nil); d != nil {
+		t.Errorf("dumpMetadataFromAttributes(nil) = %+v, want nil", d)
+	}
+}
+
+func TestReadManifestDump(t *testing.T) {
+	ctx := context.Background()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	b := newFileBucket(t, filepath.Join(dir, "store"))
+	d := &DumpMetadata{StartTime: time.Date(2019, 3, 25, 11, 29, 58, 0, time.UTC), BinlogFile: "tidb-binlog", BinlogPos: 1}
+
+	if got, err := ReadManifestDump(ctx, b, "backup"); err != nil || got != nil {
+		t.Fatalf("ReadManifestDump without manifest = %+v, %v", got, err)
+	}
+	if err := WriteManifest(ctx, b, "backup", &Manifest{Dump: d}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadManifestDump(ctx, b, "backup"); err != nil || !reflect.DeepEqual(got, d) {
+		t.Fatalf("ReadManifestDump = %+v, %v, want %+v", got, err, d)
+	}
+
+	// A corrupted manifest is still listed with its binlog position.
+	if err := b.WriteAll(ctx, ManifestKey("backup"), []byte("{"), &blob.WriterOptions{Metadata: d.metadata()}); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := DescribeBackups(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Status != StatusDamaged || !reflect.DeepEqual(infos[0].Dump, d) {
+		t.Fatalf("DescribeBackups = %+v, want backup damaged with %+v", infos, d)
+	}
+}
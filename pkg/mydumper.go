@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// outputTailLines is the number of lines of the output of a failed tool
+// included in its error.
+const outputTailLines = 20
+
+// DumpOptions configures a mydumper run.
+type DumpOptions struct {
+	// Path of the mydumper binary.
+	Path     string
+	Host     string
+	Port     int
+	User     string
+	Password string
+	// Args are passed to mydumper after the connection flags.
+	Args []string
+}
+
+// Dump runs mydumper to dump the database into dir. Its output is logged as
+// it runs and the end of it is included in the error if mydumper fails.
+func Dump(ctx context.Context, dir string, opts DumpOptions) error {
+	args := []string{
+		"--outputdir", dir,
+		"--host", opts.Host,
+		"--port", strconv.Itoa(opts.Port),
+		"--user", opts.User,
+	}
+	if opts.Password != "" {
+		args = append(args, "--password", opts.Password)
+	}
+	return runTool(ctx, "mydumper", opts.Path, append(args, opts.Args...))
+}
+
+// runTool runs the binary at path, logging its output line by line prefixed
+// by name.
+func runTool(ctx context.Context, name, path string, args []string) error {
+	out := &toolOutput{name: name}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	log.Printf("Begin %s", name)
+	err := cmd.Run()
+	out.flush()
+	if err != nil {
+		if len(out.tail) == 0 {
+			return fmt.Errorf("%s failed: %v", name, err)
+		}
+		return fmt.Errorf("%s failed: %v, output:\n  %s", name, err, strings.Join(out.tail, "\n  "))
+	}
+	log.Printf("%s finished successfully", name)
+	return nil
+}
+
+// toolOutput logs the lines written to it and keeps the last ones.
+type toolOutput struct {
+	name string
+	buf  []byte
+	tail []string
+}
+
+func (o *toolOutput) Write(p []byte) (int, error) {
+	o.buf = append(o.buf, p...)
+	for {
+		i := bytes.IndexByte(o.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		o.line(string(o.buf[:i]))
+		o.buf = o.buf[i+1:]
+	}
+}
+
+func (o *toolOutput) flush() {
+	if len(o.buf) > 0 {
+		o.line(string(o.buf))
+		o.buf = nil
+	}
+}
+
+func (o *toolOutput) line(s string) {
+	log.Printf("%s: %s", o.name, s)
+	o.tail = append(o.tail, s)
+	if len(o.tail) > outputTailLines {
+		o.tail = o.tail[1:]
+	}
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// PruneOptions is the retention policy applied by Prune. A backup is kept as
+// long as any of the rules keeps it.
+type PruneOptions struct {
+	// KeepLast is the number of most recent backups kept.
+	KeepLast int
+	// KeepWithin keeps the backups more recent than this duration.
+	KeepWithin time.Duration
+	// KeepDaily, KeepWeekly and KeepMonthly keep the most recent backup of
+	// that many of the last days, ISO weeks and months having backups, in UTC.
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	// DryRun only reports the backups that would be deleted.
+	DryRun bool
+}
+
+// Prune deletes the complete backups falling out of the retention policy and
+// returns their names. The most recent complete backup is always kept.
+// Incomplete backups are never deleted since they may still be uploading, nor
+// are damaged ones, which are left for investigation.
+func Prune(ctx context.Context, b *blob.Bucket, opts PruneOptions) ([]string, error) {
+	if opts.KeepLast <= 0 && opts.KeepWithin <= 0 && opts.KeepDaily <= 0 && opts.KeepWeekly <= 0 && opts.KeepMonthly <= 0 {
+		return nil, errors.New("no retention policy given")
+	}
+	infos, err := DescribeBackups(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	var complete []*BackupInfo
+	for _, info := range infos {
+		if info.Status != StatusComplete {
+			log.Printf("Skip %s backup: %s", info.Status, info.Name)
+			continue
+		}
+		complete = append(complete, info)
+	}
+	sort.Slice(complete, func(i, j int) bool { return complete[i].Time.After(complete[j].Time) })
+
+	keep := opts.keep(complete, time.Now())
+	var deleted []string
+	for _, info := range complete {
+		if reasons, ok := keep[info.Name]; ok {
+			log.Printf("Keep backup: %s (%s)", info.Name, strings.Join(reasons, ", "))
+			continue
+		}
+		if opts.DryRun {
+			log.Printf("Would delete backup: %s", info.Name)
+		} else {
+			if err := DeleteBackup(ctx, b, info.Name); err != nil {
+				return deleted, fmt.Errorf("failed to delete backup %s: %v", info.Name, err)
+			}
+			log.Printf("Delete backup: %s successfully", info.Name)
+		}
+		deleted = append(deleted, info.Name)
+	}
+	return deleted, nil
+}
+
+// keep returns the reasons each backup to be kept is kept for, by name.
+// Backups must be sorted from the most recent.
+func (opts PruneOptions) keep(backups []*BackupInfo, now time.Time) map[string][]string {
+	keep := make(map[string][]string)
+	if len(backups) == 0 {
+		return keep
+	}
+	keep[backups[0].Name] = []string{"newest"}
+	for i, info := range backups {
+		if i < opts.KeepLast {
+			keep[info.Name] = append(keep[info.Name], "last")
+		}
+		if opts.KeepWithin > 0 && now.Sub(info.Time) <= opts.KeepWithin {
+			keep[info.Name] = append(keep[info.Name], "within")
+		}
+	}
+
+	// Each period keeps its most recent backup, until the number of periods
+	// asked for is reached.
+	periods := func(reason string, n int, period func(t time.Time) string) {
+		seen := make(map[string]bool)
+		for _, info := range backups {
+			if len(seen) >= n {
+				return
+			}
+			p := period(info.Time.UTC())
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			keep[info.Name] = append(keep[info.Name], reason)
+		}
+	}
+	periods("daily", opts.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	periods("weekly", opts.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	periods("monthly", opts.KeepMonthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+	return keep
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testBackups returns backups named after their RFC 3339 times, which must be
+// given from the most recent.
+func testBackups(t *testing.T, times ...string) []*BackupInfo {
+	var backups []*BackupInfo
+	for _, s := range times {
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backups = append(backups, &BackupInfo{Name: s, Time: tm, Status: StatusComplete})
+	}
+	return backups
+}
+
+func TestPruneKeep(t *testing.T) {
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name    string
+		opts    PruneOptions
+		backups []string
+		want    map[string][]string
+	}{
+		{
+			name:    "no backups",
+			opts:    PruneOptions{KeepLast: 1},
+			backups: nil,
+			want:    map[string][]string{},
+		},
+		{
+			name:    "newest is always kept",
+			opts:    PruneOptions{KeepWithin: time.Hour},
+			backups: []string{"2021-02-01T00:00:00Z", "2021-01-01T00:00:00Z"},
+			want: map[string][]string{
+				"2021-02-01T00:00:00Z": {"newest"},
+			},
+		},
+		{
+			name:    "keep last",
+			opts:    PruneOptions{KeepLast: 2},
+			backups: []string{"2021-03-02T00:00:00Z", "2021-03-01T00:00:00Z", "2021-02-28T00:00:00Z"},
+			want: map[string][]string{
+				"2021-03-02T00:00:00Z": {"newest", "last"},
+				"2021-03-01T00:00:00Z": {"last"},
+			},
+		},
+		{
+			name:    "keep last more than there are",
+			opts:    PruneOptions{KeepLast: 5},
+			backups: []string{"2021-03-02T00:00:00Z", "2021-03-01T00:00:00Z"},
+			want: map[string][]string{
+				"2021-03-02T00:00:00Z": {"newest", "last"},
+				"2021-03-01T00:00:00Z": {"last"},
+			},
+		},
+		{
+			name:    "keep within",
+			opts:    PruneOptions{KeepWithin: 24 * time.Hour},
+			backups: []string{"2021-03-02T11:00:00Z", "2021-03-01T12:00:00Z", "2021-03-01T11:59:59Z"},
+			want: map[string][]string{
+				"2021-03-02T11:00:00Z": {"newest", "within"},
+				"2021-03-01T12:00:00Z": {"within"},
+			},
+		},
+		{
+			name: "daily",
+			opts: PruneOptions{KeepDaily: 2},
+			backups: []string{
+				"2021-03-02T23:00:00Z",
+				"2021-03-02T00:00:00Z",
+				"2021-03-01T23:59:59Z",
+				"2021-03-01T00:00:00Z",
+				"2021-02-28T12:00:00Z",
+			},
+			want: map[string][]string{
+				"2021-03-02T23:00:00Z": {"newest", "daily"},
+				"2021-03-01T23:59:59Z": {"daily"},
+			},
+		},
+		{
+			name:    "daily in UTC",
+			opts:    PruneOptions{KeepDaily: 2},
+			backups: []string{"2021-03-02T01:00:00+02:00", "2021-03-01T20:00:00Z", "2021-02-28T12:00:00Z"},
+			want: map[string][]string{
+				// 2021-03-01T23:00:00Z, the same day as the next backup.
+				"2021-03-02T01:00:00+02:00": {"newest", "daily"},
+				"2021-02-28T12:00:00Z":      {"daily"},
+			},
+		},
+		{
+			name: "weekly",
+			opts: PruneOptions{KeepWeekly: 2},
+			backups: []string{
+				"2021-03-01T00:00:00Z", // Monday of 2021-W09
+				"2021-02-28T23:59:59Z", // Sunday of 2021-W08
+				"2021-02-22T00:00:00Z", // Monday of 2021-W08
+				"2021-02-21T00:00:00Z", // 2021-W07
+			},
+			want: map[string][]string{
+				"2021-03-01T00:00:00Z": {"newest", "weekly"},
+				"2021-02-28T23:59:59Z": {"weekly"},
+			},
+		},
+		{
+			name: "weekly across ISO years",
+			opts: PruneOptions{KeepWeekly: 3},
+			backups: []string{
+				"2021-01-04T00:00:00Z", // 2021-W01
+				"2021-01-03T00:00:00Z", // 2020-W53
+				"2020-12-28T00:00:00Z", // 2020-W53
+				"2020-12-27T00:00:00Z", // 2020-W52
+			},
+			want: map[string][]string{
+				"2021-01-04T00:00:00Z": {"newest", "weekly"},
+				"2021-01-03T00:00:00Z": {"weekly"},
+				"2020-12-27T00:00:00Z": {"weekly"},
+			},
+		},
+		{
+			name: "weekly in the next ISO year",
+			opts: PruneOptions{KeepWeekly: 5},
+			backups: []string{
+				"2020-01-01T00:00:00Z", // 2020-W01
+				"2019-12-30T00:00:00Z", // 2020-W01
+				"2019-12-29T00:00:00Z", // 2019-W52
+			},
+			want: map[string][]string{
+				"2020-01-01T00:00:00Z": {"newest", "weekly"},
+				"2019-12-29T00:00:00Z": {"weekly"},
+			},
+		},
+		{
+			name: "monthly",
+			opts: PruneOptions{KeepMonthly: 2},
+			backups: []string{
+				"2021-03-01T00:00:00Z",
+				"2021-02-28T23:59:59Z",
+				"2021-02-01T00:00:00Z",
+				"2021-01-31T00:00:00Z",
+			},
+			want: map[string][]string{
+				"2021-03-01T00:00:00Z": {"newest", "monthly"},
+				"2021-02-28T23:59:59Z": {"monthly"},
+			},
+		},
+		{
+			name:    "monthly across years",
+			opts:    PruneOptions{KeepMonthly: 2},
+			backups: []string{"2021-01-01T00:00:00Z", "2020-12-31T23:59:59Z", "2020-12-01T00:00:00Z"},
+			want: map[string][]string{
+				"2021-01-01T00:00:00Z": {"newest", "monthly"},
+				"2020-12-31T23:59:59Z": {"monthly"},
+			},
+		},
+		{
+			name: "rules combined",
+			opts: PruneOptions{KeepLast: 1, KeepDaily: 2, KeepMonthly: 2},
+			backups: []string{
+				"2021-03-02T00:00:00Z",
+				"2021-03-01T00:00:00Z",
+				"2021-02-15T00:00:00Z",
+				"2021-01-15T00:00:00Z",
+			},
+			want: map[string][]string{
+				"2021-03-02T00:00:00Z": {"newest", "last", "daily", "monthly"},
+				"2021-03-01T00:00:00Z": {"daily"},
+				"2021-02-15T00:00:00Z": {"monthly"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.opts.keep(testBackups(t, tc.backups...), now)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("keep = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
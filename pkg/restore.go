@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+// RestoreOptions configures Restore.
+type RestoreOptions struct {
+	Download DownloadOptions
+	Load     LoadOptions
+	// WorkDir is the local directory the backup is downloaded to before
+	// being loaded.
+	WorkDir string
+	// KeepDownload keeps the downloaded backup once loaded, or when the
+	// restore fails so that it can be resumed.
+	KeepDownload bool
+}
+
+// restoreState identifies the backup a restore downloads. It is recorded next
+// to the download directory while the restore runs and kept when it fails, so
+// that running the same restore again resumes the download.
+type restoreState struct {
+	Backup    string    `json:"backup"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// restoreStatePath returns the path of the file recording the state of the
+// restore downloading into dir, next to it.
+func restoreStatePath(dir string) string {
+	return filepath.Clean(dir) + ".restore"
+}
+
+// resumes reports whether the restore state recorded at path is the one of s,
+// i.e. whether the download directory was left by a failed restore of the
+// same backup.
+func (s restoreState) resumes(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var prev restoreState
+	if err = json.Unmarshal(data, &prev); err != nil {
+		return false
+	}
+	return prev.Backup == s.Backup && prev.StartTime.Equal(s.StartTime)
+}
+
+// Restore downloads the backup name, verifying it against its manifest, and
+// loads it into TiDB. Incomplete backups are always refused. A non-empty
+// download directory is refused too, unless it was left by a failed restore
+// of the same backup with KeepDownload, which is then resumed.
+func Restore(ctx context.Context, b *blob.Bucket, name string, opts RestoreOptions) error {
+	name = strings.TrimSuffix(name, "/")
+	m, err := ReadManifest(ctx, b, name)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return &IncompleteError{Prefix: name, NoManifest: true}
+	}
+	state := restoreState{Backup: name, StartTime: m.StartTime}
+	dir := filepath.Join(opts.WorkDir, filepath.FromSlash(name))
+	statePath := restoreStatePath(dir)
+	if files, err := ioutil.ReadDir(dir); err == nil && len(files) > 0 {
+		if !state.resumes(statePath) {
+			return fmt.Errorf("download directory %s is not empty", dir)
+		}
+		log.Printf("Resuming restore of %s into %s", name, dir)
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(statePath, data, 0644); err != nil {
+		return err
+	}
+	if !opts.KeepDownload {
+		defer func() {
+			if err := os.RemoveAll(dir); err != nil {
+				log.Printf("Failed to remove download directory %s: %v", dir, err)
+			}
+		}()
+	}
+
+	opts.Download.AllowIncomplete = false
+	err = NewDownloader(b, opts.Download).Download(ctx, name, dir)
+	if err == nil {
+		err = Load(ctx, dir, opts.Load)
+	}
+	// Only the download of a failed restore is resumed, a successful one
+	// is never loaded twice.
+	if err == nil || !opts.KeepDownload {
+		if rerr := os.Remove(statePath); rerr != nil {
+			log.Printf("Failed to remove restore state %s: %v", statePath, rerr)
+		}
+	}
+	return err
+}
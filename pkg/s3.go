@@ -0,0 +1,186 @@
+package pkg
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/s3blob"
+)
+
+// defaultS3Region is used to sign requests when neither the options nor the
+// AWS_REGION environment variable give a region. S3-compatible stores such as
+// MinIO and Ceph RGW accept it regardless of where they run.
+const defaultS3Region = "us-east-1"
+
+// S3Options configures a connection to an S3-compatible object store. Fields
+// left nil or empty keep the AWS SDK default, or the value of the preset they
+// are merged into.
+type S3Options struct {
+	// Region of the bucket. It is looked up from the bucket if empty, using
+	// AWS_REGION as a hint.
+	Region string
+	// ForcePathStyle addresses buckets as endpoint/bucket instead of the
+	// virtual-host style bucket.endpoint.
+	ForcePathStyle *bool
+	// TLS selects https, or plain http when false.
+	TLS *bool
+	// CABundle is the path of a PEM file with the certificates trusted in
+	// addition to the system ones, for stores using a private CA.
+	CABundle string
+	// MaxRetries is the number of times a failed request is retried.
+	MaxRetries *int
+	// CreateBucket creates the bucket if it does not exist yet.
+	CreateBucket *bool
+}
+
+var (
+	// AWSPreset is used by the aws provider.
+	AWSPreset = S3Options{}
+	// CephPreset is used by the ceph provider, for ROOK Ceph object stores
+	// reached through their in-cluster http service.
+	CephPreset = S3Options{
+		Region:         "us-east-1",
+		ForcePathStyle: aws.Bool(true),
+		TLS:            aws.Bool(false),
+		MaxRetries:     aws.Int(20),
+		CreateBucket:   aws.Bool(true),
+	}
+)
+
+// Merge returns o with every field set in override replacing its own.
+func (o S3Options) Merge(override S3Options) S3Options {
+	if override.Region != "" {
+		o.Region = override.Region
+	}
+	if override.ForcePathStyle != nil {
+		o.ForcePathStyle = override.ForcePathStyle
+	}
+	if override.TLS != nil {
+		o.TLS = override.TLS
+	}
+	if override.CABundle != "" {
+		o.CABundle = override.CABundle
+	}
+	if override.MaxRetries != nil {
+		o.MaxRetries = override.MaxRetries
+	}
+	if override.CreateBucket != nil {
+		o.CreateBucket = override.CreateBucket
+	}
+	return o
+}
+
+// region returns the region of o, falling back to the AWS_REGION environment
+// variable and then to defaultS3Region.
+func (o S3Options) region() string {
+	if o.Region != "" {
+		return o.Region
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return defaultS3Region
+}
+
+// config returns the AWS SDK configuration described by o.
+func (o S3Options) config(endpoint string) *aws.Config {
+	c := aws.NewConfig().WithRegion(o.region())
+	if endpoint != "" {
+		c.WithEndpoint(endpoint)
+	}
+	if o.ForcePathStyle != nil {
+		c.WithS3ForcePathStyle(*o.ForcePathStyle)
+	}
+	if o.TLS != nil {
+		c.WithDisableSSL(!*o.TLS)
+	}
+	if o.MaxRetries != nil {
+		c.WithMaxRetries(*o.MaxRetries)
+	}
+	return c
+}
+
+// newS3Session creates an AWS SDK session for the store described by opts.
+func newS3Session(endpoint string, opts S3Options, creds CredentialOptions) (*session.Session, error) {
+	so := session.Options{Config: *opts.config(endpoint)}
+	c, err := AWSCredentials(creds, opts.region())
+	if err != nil {
+		return nil, err
+	}
+	so.Config.Credentials = c
+	if opts.CABundle != "" {
+		f, err := os.Open(opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		so.CustomCABundle = f
+	}
+	return session.NewSessionWithOptions(so)
+}
+
+// SetupS3 creates a connection to an S3-compatible object store, AWS S3 itself
+// when endpoint is empty.
+func SetupS3(ctx context.Context, bucket, endpoint string, opts S3Options, creds CredentialOptions) (*blob.Bucket, error) {
+	s, err := newS3Session(endpoint, opts, creds)
+	if err != nil {
+		return nil, err
+	}
+	if aws.BoolValue(opts.CreateBucket) {
+		if err := checkBucket(bucket, s); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Region == "" {
+		region, err := bucketRegion(ctx, s, endpoint, bucket)
+		if err != nil {
+			log.Printf("Failed to look up region of bucket %s, using %s: %v", bucket, aws.StringValue(s.Config.Region), err)
+		} else {
+			s.Config.Region = aws.String(region)
+		}
+	}
+	return s3blob.OpenBucket(ctx, s, bucket, nil)
+}
+
+// bucketRegions caches the regions looked up by bucketRegion for the lifetime
+// of the process, keyed by endpoint and bucket.
+var bucketRegions = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// bucketRegion looks up the region of bucket. The region header returned by
+// HeadBucket is used first since it is available to anyone; stores which do
+// not send it are asked with GetBucketLocation.
+func bucketRegion(ctx context.Context, s *session.Session, endpoint, bucket string) (string, error) {
+	key := endpoint + "/" + bucket
+	bucketRegions.Lock()
+	region, ok := bucketRegions.m[key]
+	bucketRegions.Unlock()
+	if ok {
+		return region, nil
+	}
+
+	region, err := s3manager.GetBucketRegion(ctx, s, bucket, aws.StringValue(s.Config.Region))
+	if err != nil {
+		out, lerr := s3.New(s).GetBucketLocationWithContext(ctx, &s3.GetBucketLocationInput{
+			Bucket: aws.String(bucket),
+		})
+		if lerr != nil {
+			return "", err
+		}
+		region = s3.NormalizeBucketLocation(aws.StringValue(out.LocationConstraint))
+	}
+
+	bucketRegions.Lock()
+	bucketRegions.m[key] = region
+	bucketRegions.Unlock()
+	return region, nil
+}
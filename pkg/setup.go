@@ -3,38 +3,71 @@ package pkg
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/tennix/tidb-cloud-backup/pkg/azureblob"
 	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
 	"gocloud.dev/blob/gcsblob"
-	"gocloud.dev/blob/s3blob"
 	"gocloud.dev/gcp"
 )
 
+// BucketOptions holds the provider specific settings of a bucket.
+type BucketOptions struct {
+	// Endpoint is the service URL of S3-compatible and Azure stores, or the
+	// root directory of the file provider.
+	Endpoint string
+	// S3 configures the s3 provider and overrides the aws and ceph presets.
+	S3 S3Options
+	// Credentials selects the credential sources of the aws, ceph, s3 and
+	// gcp providers.
+	Credentials CredentialOptions
+}
+
+// CloudProviders are the cloud providers supported by SetupBucket.
+var CloudProviders = []string{"aws", "gcp", "ceph", "s3", "azure", "file"}
+
+// ValidCloudProvider reports whether cloud is one of CloudProviders.
+func ValidCloudProvider(cloud string) bool {
+	for _, c := range CloudProviders {
+		if c == cloud {
+			return true
+		}
+	}
+	return false
+}
+
 // SetupBucket creates a connection to a particular cloud provider's blob storage.
-func SetupBucket(ctx context.Context, cloud, bucket, endpoint string) (*blob.Bucket, error) {
+func SetupBucket(ctx context.Context, cloud, bucket string, opts *BucketOptions) (*blob.Bucket, error) {
+	if opts == nil {
+		opts = &BucketOptions{}
+	}
 	switch cloud {
 	case "aws":
-		return SetupAWS(ctx, bucket)
+		return SetupS3(ctx, bucket, opts.Endpoint, AWSPreset.Merge(opts.S3), opts.Credentials)
 	case "gcp":
-		return SetupGCP(ctx, bucket)
+		return SetupGCP(ctx, bucket, opts.Credentials)
 	case "ceph":
-		return SetupCeph(ctx, bucket, endpoint)
+		return SetupS3(ctx, bucket, opts.Endpoint, CephPreset.Merge(opts.S3), opts.Credentials)
+	case "s3":
+		return SetupS3(ctx, bucket, opts.Endpoint, opts.S3, opts.Credentials)
+	case "azure":
+		return SetupAzure(ctx, bucket, opts.Endpoint)
+	case "file":
+		return SetupFile(ctx, bucket, opts.Endpoint)
 	default:
 		return nil, fmt.Errorf("invalid cloud provider: %s", cloud)
 	}
 }
 
 // SetupGCP creates a connection to Google Cloud Storage (GCS).
-func SetupGCP(ctx context.Context, bucket string) (*blob.Bucket, error) {
-	// DefaultCredentials assumes a user has logged in with gcloud.
-	// See here for more information:
-	// https://cloud.google.com/docs/authentication/getting-started
-	creds, err := gcp.DefaultCredentials(ctx)
+func SetupGCP(ctx context.Context, bucket string, opts CredentialOptions) (*blob.Bucket, error) {
+	creds, err := GCPCredentials(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -47,17 +80,7 @@ func SetupGCP(ctx context.Context, bucket string) (*blob.Bucket, error) {
 
 // SetupAWS creates a connection to Simple Cloud Storage Service (S3).
 func SetupAWS(ctx context.Context, bucket string) (*blob.Bucket, error) {
-	c := &aws.Config{
-		// Either hard-code the region or use AWS_REGION.
-		Region: aws.String("us-east-2"),
-		// credentials.NewEnvCredentials assumes two environment variables are
-		// present:
-		// 1. AWS_ACCESS_KEY_ID, and
-		// 2. AWS_SECRET_ACCESS_KEY.
-		Credentials: credentials.NewEnvCredentials(),
-	}
-	s := session.Must(session.NewSession(c))
-	return s3blob.OpenBucket(ctx, s, bucket, nil)
+	return SetupS3(ctx, bucket, "", AWSPreset, CredentialOptions{})
 }
 
 // S3Helper contains pointer to s3 client and wrappers for basic object store operations
@@ -87,9 +110,8 @@ func (h *S3Helper) CreateBucket(name string) error {
 }
 
 // checkBucket creates bucket if it's not present
-func checkBucket(bucket string, config *aws.Config) error {
-	s := session.Must(session.NewSession(config))
-	c := s3.New(s, config)
+func checkBucket(bucket string, s *session.Session) error {
+	c := s3.New(s)
 	s3helper := &S3Helper{c}
 	result, err := s3helper.IsBucketPresent(bucket)
 	if result == false && err == nil {
@@ -102,25 +124,30 @@ func checkBucket(bucket string, config *aws.Config) error {
 // See here for more information:
 // https://rook.io/docs/rook/v0.9/ceph-object.html
 func SetupCeph(ctx context.Context, bucket, endpoint string) (*blob.Bucket, error) {
-	// credentials.NewEnvCredentials assumes two environment variables are
-	// present:
-	// 1. AWS_ACCESS_KEY_ID, and
-	// 2. AWS_SECRET_ACCESS_KEY.
-	creds := credentials.NewEnvCredentials()
+	return SetupS3(ctx, bucket, endpoint, CephPreset, CredentialOptions{})
+}
 
-	awsConfig := aws.NewConfig().
-		WithRegion("us-east-1").
-		WithCredentials(creds).
-		WithEndpoint(endpoint).
-		WithS3ForcePathStyle(true).
-		WithDisableSSL(true).
-		WithMaxRetries(20)
+// SetupAzure creates a connection to an Azure Blob Storage container. The
+// endpoint defaults to https://<account>.blob.core.windows.net.
+func SetupAzure(ctx context.Context, container, endpoint string) (*blob.Bucket, error) {
+	// The following environment variables are used, as with the az CLI:
+	// 1. AZURE_STORAGE_ACCOUNT, and
+	// 2. either AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN.
+	return azureblob.OpenBucket(ctx, container, &azureblob.Options{
+		AccountName: os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		AccountKey:  os.Getenv("AZURE_STORAGE_KEY"),
+		SASToken:    os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+		Endpoint:    endpoint,
+	})
+}
 
-	err := checkBucket(bucket, awsConfig)
-	if err != nil {
+// SetupFile opens a directory on a local or NFS-mounted filesystem as a
+// bucket. The bucket is the directory named bucket under the endpoint path,
+// and it is created if it does not exist yet.
+func SetupFile(ctx context.Context, bucket, endpoint string) (*blob.Bucket, error) {
+	dir := filepath.Join(endpoint, bucket)
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
-
-	s := session.Must(session.NewSession(awsConfig))
-	return s3blob.OpenBucket(ctx, s, bucket, nil)
+	return fileblob.OpenBucket(dir, nil)
 }
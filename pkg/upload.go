@@ -0,0 +1,404 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// DefaultBufferSize is the default part size used by blob writers. It is the
+// minimum part size accepted by S3 multipart uploads.
+const DefaultBufferSize = int(s3manager.MinUploadPartSize)
+
+// UploadOptions controls how files are streamed into a bucket.
+type UploadOptions struct {
+	// BufferSize is the size in bytes of the chunks sent to the bucket. It
+	// is raised for files too large to fit in the number of parts allowed by
+	// S3 multipart uploads.
+	BufferSize int
+	// MemoryLimit caps the memory used by all in-flight writers; 0 means no limit.
+	MemoryLimit int64
+	// Concurrency is the number of files uploaded in parallel.
+	Concurrency int
+	// ChecksumAlgorithm is the algorithm used for the manifest checksums.
+	ChecksumAlgorithm string
+	// EncryptionKey encrypts every object client-side when set.
+	EncryptionKey *EncryptionKey
+	// Compression is the codec used to compress files that are not
+	// compressed already.
+	Compression string
+	// CheckpointPath is the file recording the progress of UploadDir, so
+	// that it skips the files already uploaded when run again after being
+	// interrupted. No checkpoint is kept if empty, or if the file cannot be
+	// written.
+	CheckpointPath string
+	// Filter selects the files uploaded by UploadDir, all of them if nil.
+	Filter *Filter
+}
+
+// Uploader streams local files into a bucket with bounded memory usage.
+type Uploader struct {
+	bucket *blob.Bucket
+	opts   UploadOptions
+	budget *MemoryBudget
+}
+
+// NewUploader creates an Uploader writing into b.
+func NewUploader(b *blob.Bucket, opts UploadOptions) *Uploader {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.ChecksumAlgorithm == "" {
+		opts.ChecksumAlgorithm = DefaultChecksumAlgorithm
+	}
+	if opts.Compression == "" {
+		opts.Compression = CompressionNone
+	}
+	return &Uploader{
+		bucket: b,
+		opts:   opts,
+		budget: NewMemoryBudget(opts.MemoryLimit),
+	}
+}
+
+// maxUploadParts is the number of parts a file is split into at most. It is
+// below the 10,000 parts allowed by S3 multipart uploads to leave room for
+// incompressible data growing when compressed and for the encryption overhead.
+const maxUploadParts = 9000
+
+// bufferSize returns the part size used to upload a file of size bytes: the
+// configured BufferSize, raised to a whole number of MiB when the file would
+// not fit in maxUploadParts parts otherwise.
+func (u *Uploader) bufferSize(size int64) int {
+	n := int64(u.opts.BufferSize)
+	if min := (size + maxUploadParts - 1) / maxUploadParts; min > n {
+		n = (min + 1<<20 - 1) &^ (1<<20 - 1)
+	}
+	return int(n)
+}
+
+// writerFootprint estimates the memory held by one open blob.Writer using
+// parts of bufferSize bytes. The S3 upload manager keeps up to
+// DefaultUploadConcurrency parts in flight, the other providers buffer a
+// single chunk.
+func (u *Uploader) writerFootprint(bufferSize int) int64 {
+	n := int64(bufferSize) * s3manager.DefaultUploadConcurrency
+	if u.opts.Compression != CompressionNone {
+		n += compressionFootprint
+	}
+	return n
+}
+
+// UploadFile streams the local file at path into the bucket under key and
+// returns its manifest entry. The size and checksum in the entry describe the
+// object as stored, i.e. after compression and encryption, and those of the
+// local file are recorded as well when they differ.
+func (u *Uploader) UploadFile(ctx context.Context, path, key string) (*ManifestObject, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h, err := NewChecksum(u.opts.ChecksumAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	source, err := NewChecksum(u.opts.ChecksumAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	src := bufio.NewReader(f)
+	codec := u.opts.Compression
+	if codec != CompressionNone {
+		if header, _ := src.Peek(len(zstdMagic)); isCompressed(header) {
+			codec = CompressionNone
+		}
+	}
+
+	// Large files get larger parts, which count against the memory budget
+	// like any other buffer.
+	bufferSize := u.bufferSize(info.Size())
+	footprint := u.writerFootprint(bufferSize)
+	if err = u.budget.Acquire(ctx, footprint); err != nil {
+		return nil, err
+	}
+	defer u.budget.Release(footprint)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wopts := &blob.WriterOptions{
+		BufferSize: bufferSize,
+		Metadata:   make(map[string]string),
+	}
+	if codec != CompressionNone {
+		wopts.Metadata[metaCompression] = codec
+	}
+	if u.opts.EncryptionKey != nil {
+		for k, v := range u.opts.EncryptionKey.metadata() {
+			wopts.Metadata[k] = v
+		}
+	}
+	if len(wopts.Metadata) > 0 {
+		// Sniffing the content type of transformed content is pointless,
+		// and the sniffing buffer of blob.Writer reports short writes for
+		// the small compression and encryption headers.
+		wopts.ContentType = "application/octet-stream"
+	}
+	w, err := u.bucket.NewWriter(ctx, key, wopts)
+	if err != nil {
+		return nil, err
+	}
+	stored := &countingWriter{}
+	sum := md5.New()
+	// The content of objects stored as is is already described by the
+	// stored checksum.
+	transformed := codec != CompressionNone || u.opts.EncryptionKey != nil
+	read := &countingWriter{}
+	var in io.Reader = src
+	if transformed {
+		in = io.TeeReader(src, io.MultiWriter(source, read))
+	}
+	if err = u.copyTransformed(io.MultiWriter(w, h, sum, stored), in, codec); err != nil {
+		// Cancelling the context before Close aborts the write, so a
+		// partially copied file never shows up in the bucket.
+		cancel()
+		w.Close()
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	obj := &ManifestObject{
+		Key:      key,
+		Size:     stored.n,
+		Checksum: hex.EncodeToString(h.Sum(nil)),
+		MD5:      hex.EncodeToString(sum.Sum(nil)),
+		ModTime:  info.ModTime().UTC(),
+	}
+	if transformed {
+		obj.SourceSize = read.n
+		obj.SourceChecksum = hex.EncodeToString(source.Sum(nil))
+	}
+	return obj, nil
+}
+
+// copyTransformed copies src into dst, compressing it with codec and then
+// encrypting it if an encryption key is configured.
+func (u *Uploader) copyTransformed(dst io.Writer, src io.Reader, codec string) error {
+	var closers []io.Closer
+	if u.opts.EncryptionKey != nil {
+		enc, err := NewEncryptWriter(dst, u.opts.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		closers = append(closers, enc)
+		dst = enc
+	}
+	if codec != CompressionNone {
+		cw, err := newCompressor(dst, codec)
+		if err != nil {
+			return err
+		}
+		closers = append(closers, cw)
+		dst = cw
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	// Close the outermost writer first so that each one flushes into the
+	// next before it is closed in turn.
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UploadDir uploads every regular file below dir to the bucket, keyed by its
+// path relative to dir under prefix. Files are uploaded by a pool of
+// Concurrency workers; failures do not stop the walk and are returned together
+// as TransferErrors once every file has been attempted. Files not selected by
+// Filter are skipped and left out of the manifest, which records the filter.
+// The backup manifest is written only when every file has been uploaded
+// successfully, along with the metadata of the mydumper metadata file of dir,
+// if any. A file named like the manifest at the root of dir is refused before
+// anything is uploaded, and the manifest of an earlier upload to prefix is
+// deleted first.
+func (u *Uploader) UploadDir(ctx context.Context, dir, prefix string) (*Manifest, error) {
+	type job struct {
+		path string
+		key  string
+		info os.FileInfo
+	}
+	var (
+		jobs     = make(chan job)
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		errs     TransferErrors
+		manifest = &Manifest{
+			Version:           ManifestVersion,
+			Name:              prefix,
+			StartTime:         time.Now().UTC(),
+			ChecksumAlgorithm: u.opts.ChecksumAlgorithm,
+			Filter:            u.opts.Filter.manifest(),
+		}
+		cp *checkpoint
+	)
+	// The manifest would overwrite a file of the same name at the root of
+	// dir, leaving a backup that never passes verification.
+	if _, err := os.Lstat(filepath.Join(dir, ManifestName)); err == nil && u.opts.Filter.Match(ManifestName) {
+		return nil, fmt.Errorf("cannot upload %s: its name is reserved for the backup manifest", filepath.Join(dir, ManifestName))
+	}
+	// A manifest left under prefix by an earlier upload would mark the
+	// backup complete while its objects are replaced, and after this upload
+	// fails.
+	if err := u.bucket.Delete(ctx, ManifestKey(prefix)); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, fmt.Errorf("delete previous manifest %s: %v", ManifestKey(prefix), err)
+	}
+	if d, err := ReadDumpMetadata(filepath.Join(dir, MydumperMetadataName)); err == nil {
+		manifest.Dump = d
+	} else if !os.IsNotExist(err) {
+		log.Printf("Failed to parse mydumper metadata, the binlog position is not recorded: %v", err)
+	}
+	if u.opts.CheckpointPath != "" {
+		header := checkpointHeader{
+			Prefix:            prefix,
+			ChecksumAlgorithm: u.opts.ChecksumAlgorithm,
+			Compression:       u.opts.Compression,
+		}
+		if u.opts.EncryptionKey != nil {
+			header.EncryptionKeyID = u.opts.EncryptionKey.ID
+		}
+		var err error
+		if cp, err = openCheckpoint(u.opts.CheckpointPath, header); err != nil {
+			log.Printf("Failed to open checkpoint %s, uploading without it: %v", u.opts.CheckpointPath, err)
+		}
+	}
+	fail := func(name string, err error) {
+		log.Printf("Upload file: %s failed, error: %v", name, err)
+		mu.Lock()
+		errs = append(errs, &FileError{Name: name, Err: err})
+		mu.Unlock()
+	}
+
+	for i := 0; i < u.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if cp != nil {
+					if obj, ok := u.uploaded(ctx, cp, j.key, j.info); ok {
+						mu.Lock()
+						manifest.Objects = append(manifest.Objects, *obj)
+						mu.Unlock()
+						log.Printf("Skip file: %s already uploaded", j.key)
+						continue
+					}
+				}
+				obj, err := u.UploadFile(ctx, j.path, j.key)
+				if err != nil {
+					fail(j.path, err)
+					continue
+				}
+				if cp != nil {
+					if err = cp.record(*obj, j.info); err != nil {
+						log.Printf("Failed to record %s in checkpoint: %v", j.key, err)
+					}
+				}
+				mu.Lock()
+				manifest.Objects = append(manifest.Objects, *obj)
+				mu.Unlock()
+				log.Printf("Upload file: %s successfully", j.key)
+			}
+		}()
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			fail(path, err)
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		// Keys mirror the layout below dir so that files sharing a
+		// basename in different subdirectories do not overwrite each other.
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			fail(path, err)
+			return nil
+		}
+		if !u.opts.Filter.Match(filepath.ToSlash(rel)) {
+			log.Printf("Skip file: %s excluded by filter", path)
+			return nil
+		}
+		select {
+		case jobs <- job{path: path, key: filepath.ToSlash(filepath.Join(prefix, rel)), info: info}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(jobs)
+	wg.Wait()
+	if err == nil && len(errs) > 0 {
+		err = errs
+	}
+	if err == nil {
+		manifest.FinishTime = time.Now().UTC()
+		err = WriteManifest(ctx, u.bucket, prefix, manifest)
+	}
+	// The checkpoint is only needed until the manifest is written.
+	if cp != nil {
+		cp.close(err == nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// uploaded returns the manifest entry of key if the checkpoint records it as
+// uploaded from the current version of the local file and the object is still
+// in the bucket with the recorded size.
+func (u *Uploader) uploaded(ctx context.Context, cp *checkpoint, key string, info os.FileInfo) (*ManifestObject, bool) {
+	e, ok := cp.lookup(key, info)
+	if !ok {
+		return nil, false
+	}
+	attrs, err := u.bucket.Attributes(ctx, key)
+	if err != nil || attrs.Size != e.Object.Size {
+		return nil, false
+	}
+	return &e.Object, true
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gocloud.dev/blob"
+)
+
+// tempDir creates a temporary directory removed by the returned function.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "tidb-cloud-backup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// newFileBucket opens a bucket of the file provider below dir.
+func newFileBucket(t *testing.T, dir string) *blob.Bucket {
+	b, err := SetupFile(context.Background(), "bucket", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// writeFiles creates the files below dir, by slash separated relative path.
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFiles returns the content of the files below dir, by slash separated
+// relative path.
+func readFiles(t *testing.T, dir string) map[string][]byte {
+	files := make(map[string][]byte)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		files[filepath.ToSlash(rel)] = data
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// testDump returns the files of a small mydumper dump.
+func testDump(t *testing.T) map[string][]byte {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(strings.Repeat("INSERT INTO `t` VALUES (2);\n", 1000)))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return map[string][]byte{
+		"metadata":                []byte("Started dump at: 2019-02-13 06:11:40\nFinished dump at: 2019-02-13 06:11:41\n"),
+		"shop-schema-create.sql":  []byte("CREATE DATABASE `shop`;\n"),
+		"shop.t-schema.sql":       []byte("CREATE TABLE `t` (`id` int);\n"),
+		"shop.t.00001.sql":        []byte(strings.Repeat("INSERT INTO `t` VALUES (1);\n", 10000)),
+		"shop.t.00002.sql.gz":     gz.Bytes(),
+		"shop.empty.sql":          {},
+		"sub/shop.random.sql":     randomBytes(t, 3*encryptionChunkSize+100),
+		"sub/deeper/shop.one.sql": []byte("x"),
+	}
+}
+
+func TestUploadDownloadRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	key := newTestKey(t)
+	for _, tc := range []struct {
+		name        string
+		compression string
+		key         *EncryptionKey
+	}{
+		{"plain", CompressionNone, nil},
+		{"gzip", CompressionGzip, nil},
+		{"zstd", CompressionZstd, nil},
+		{"encrypted", CompressionNone, key},
+		{"zstd encrypted", CompressionZstd, key},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+			src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+			files := testDump(t)
+			writeFiles(t, src, files)
+			b := newFileBucket(t, filepath.Join(dir, "store"))
+
+			u := NewUploader(b, UploadOptions{Concurrency: 3, Compression: tc.compression, EncryptionKey: tc.key})
+			m, err := u.UploadDir(ctx, src, "backup")
+			if err != nil {
+				t.Fatalf("UploadDir: %v", err)
+			}
+			if len(m.Objects) != len(files) {
+				t.Fatalf("manifest has %d objects, want %d", len(m.Objects), len(files))
+			}
+			for _, obj := range m.Objects {
+				transformed := tc.key != nil || tc.compression != CompressionNone && !strings.HasSuffix(obj.Key, ".gz")
+				if transformed != (obj.SourceChecksum != "") {
+					t.Errorf("object %s has source checksum %q", obj.Key, obj.SourceChecksum)
+				}
+			}
+			if _, err = Verify(ctx, b, "backup", VerifyOptions{Checksum: true}); err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			opts := DownloadOptions{Concurrency: 3}
+			if tc.key != nil {
+				opts.Keyring = Keyring{tc.key.ID: tc.key}
+			}
+			if err = NewDownloader(b, opts).Download(ctx, "backup", dest); err != nil {
+				t.Fatalf("Download: %v", err)
+			}
+			if got := readFiles(t, dest); !reflect.DeepEqual(got, files) {
+				t.Fatalf("downloaded %d files not matching the %d uploaded", len(got), len(files))
+			}
+			// A second download finds every file in place.
+			if err = NewDownloader(b, opts).Download(ctx, "backup", dest); err != nil {
+				t.Fatalf("Download again: %v", err)
+			}
+			if got := readFiles(t, dest); !reflect.DeepEqual(got, files) {
+				t.Fatal("downloaded files changed by the second download")
+			}
+		})
+	}
+}
+
+func TestUploadResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	src := filepath.Join(dir, "src")
+	files := testDump(t)
+	writeFiles(t, src, files)
+	b := newFileBucket(t, filepath.Join(dir, "store"))
+	opts := UploadOptions{Compression: CompressionZstd, CheckpointPath: CheckpointPath(src)}
+
+	// A dangling symlink fails to upload, leaving the backup without a
+	// manifest and the other files recorded in the checkpoint.
+	broken := filepath.Join(src, "shop.broken.sql")
+	if err := os.Symlink(filepath.Join(dir, "missing"), broken); err != nil {
+		t.Skipf("cannot create symlink: %v", err)
+	}
+	if _, err := NewUploader(b, opts).UploadDir(ctx, src, "backup"); err == nil {
+		t.Fatal("UploadDir succeeded with a broken file")
+	}
+	if m, err := ReadManifest(ctx, b, "backup"); err != nil || m != nil {
+		t.Fatalf("ReadManifest = %v, %v, want no manifest", m, err)
+	}
+	if _, err := os.Stat(CheckpointPath(src)); err != nil {
+		t.Fatalf("checkpoint not kept: %v", err)
+	}
+
+	// Objects skipped by the resumed upload keep their content in the
+	// bucket, so replace one with a marker of the same size.
+	const key = "backup/shop.t-schema.sql"
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker := bytes.Repeat([]byte("m"), int(attrs.Size))
+	if err = b.WriteAll(ctx, key, marker, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Remove(broken); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewUploader(b, opts).UploadDir(ctx, src, "backup")
+	if err != nil {
+		t.Fatalf("resumed UploadDir: %v", err)
+	}
+	if len(m.Objects) != len(files) {
+		t.Fatalf("manifest has %d objects, want %d", len(m.Objects), len(files))
+	}
+	if data, err := b.ReadAll(ctx, key); err != nil || !bytes.Equal(data, marker) {
+		t.Fatalf("object %s uploaded again by the resumed upload", key)
+	}
+	if _, err := os.Stat(CheckpointPath(src)); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint not removed after the upload completed: %v", err)
+	}
+}
+
+func TestUploadDirDeletesPreviousManifest(t *testing.T) {
+	ctx := context.Background()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	src := filepath.Join(dir, "src")
+	writeFiles(t, src, testDump(t))
+	b := newFileBucket(t, filepath.Join(dir, "store"))
+	if _, err := NewUploader(b, UploadOptions{}).UploadDir(ctx, src, "backup"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed upload to the same prefix leaves it without a manifest, not
+	// with the manifest of the previous upload.
+	if err := os.Symlink(filepath.Join(dir, "missing"), filepath.Join(src, "shop.broken.sql")); err != nil {
+		t.Skipf("cannot create symlink: %v", err)
+	}
+	if _, err := NewUploader(b, UploadOptions{}).UploadDir(ctx, src, "backup"); err == nil {
+		t.Fatal("UploadDir succeeded with a broken file")
+	}
+	if m, err := ReadManifest(ctx, b, "backup"); err != nil || m != nil {
+		t.Fatalf("ReadManifest = %v, %v, want no manifest", m, err)
+	}
+}
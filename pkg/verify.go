@@ -0,0 +1,205 @@
+package pkg
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// VerifyOptions controls how thoroughly Verify checks a backup.
+type VerifyOptions struct {
+	// Checksum checks the content of every object against the manifest, not
+	// only its size. The MD5 reported by the provider is compared when both
+	// it and the manifest have one, otherwise or if they differ the object is
+	// read and hashed.
+	Checksum bool
+	// NoProviderMD5 reads and hashes every object even when the MD5 reported
+	// by the provider could be compared instead. It must be set for the
+	// providers ProviderComputesMD5 does not trust.
+	NoProviderMD5 bool
+	// Concurrency is the number of objects checked in parallel.
+	Concurrency int
+}
+
+// ProviderComputesMD5 reports whether the MD5 reported by the cloud provider
+// is computed by the store from the content it holds. The file provider
+// reports the MD5 computed at upload and recorded in a sidecar file, which
+// says nothing of the content stored since, and Azure only computes it for
+// blobs uploaded in a single request.
+func ProviderComputesMD5(cloud string) bool {
+	switch cloud {
+	case "aws", "gcp", "ceph", "s3":
+		return true
+	default:
+		return false
+	}
+}
+
+// VerifyError reports the problems found in a backup by Verify.
+type VerifyError struct {
+	Prefix string
+	// Missing are the objects listed in the manifest but not in the bucket.
+	Missing []string
+	// Extra are the objects in the bucket but not listed in the manifest.
+	Extra []string
+	// Corrupted are the objects whose size or content does not match the
+	// manifest.
+	Corrupted []string
+	// Unchecked are the objects that could not be checked, e.g. because
+	// reading them failed.
+	Unchecked []string
+}
+
+func (e *VerifyError) Error() string {
+	var problems []string
+	for _, p := range []struct {
+		name string
+		keys []string
+	}{
+		{"missing", e.Missing},
+		{"extra", e.Extra},
+		{"corrupted", e.Corrupted},
+		{"unchecked", e.Unchecked},
+	} {
+		if len(p.keys) > 0 {
+			sort.Strings(p.keys)
+			problems = append(problems, fmt.Sprintf("%d %s object(s): %s", len(p.keys), p.name, strings.Join(p.keys, ", ")))
+		}
+	}
+	return fmt.Sprintf("backup %s failed verification: %s", e.Prefix, strings.Join(problems, "; "))
+}
+
+// Verify checks the backup under prefix against its manifest without
+// downloading it: every object listed must exist with the recorded size, and
+// with Checksum set its content must match the recorded checksum. Objects
+// found under prefix but not listed are reported as extra.
+//
+// Problems are logged as they are found and returned together as a
+// *VerifyError. A backup without a manifest fails with an *IncompleteError.
+// Objects that could not be checked are returned as TransferErrors if nothing
+// else is wrong, and listed as unchecked in the *VerifyError otherwise. The
+// manifest is returned whenever it could be read.
+func Verify(ctx context.Context, b *blob.Bucket, prefix string, opts VerifyOptions) (*Manifest, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	m, err := ReadManifest(ctx, b, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, &IncompleteError{Prefix: prefix, NoManifest: true}
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	var (
+		objs = make(chan ManifestObject)
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		ve   = &VerifyError{Prefix: prefix}
+		errs TransferErrors
+	)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range objs {
+				problem, err := verifyObject(ctx, b, obj, m.ChecksumAlgorithm, opts)
+				mu.Lock()
+				switch {
+				case err != nil:
+					log.Printf("Verify file: %s failed, error: %v", obj.Key, err)
+					errs = append(errs, &FileError{Name: obj.Key, Err: err})
+				case problem == "missing":
+					log.Printf("Missing file: %s", obj.Key)
+					ve.Missing = append(ve.Missing, obj.Key)
+				case problem != "":
+					log.Printf("Corrupted file: %s, %s", obj.Key, problem)
+					ve.Corrupted = append(ve.Corrupted, obj.Key)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, obj := range m.Objects {
+		objs <- obj
+	}
+	close(objs)
+	wg.Wait()
+
+	found, err := listObjects(ctx, b, prefix)
+	if err != nil {
+		return m, err
+	}
+	listed := m.ObjectsByKey()
+	for key := range found {
+		if _, ok := listed[key]; !ok && key != ManifestKey(prefix) {
+			log.Printf("Extra file: %s", key)
+			ve.Extra = append(ve.Extra, key)
+		}
+	}
+
+	if len(ve.Missing) > 0 || len(ve.Extra) > 0 || len(ve.Corrupted) > 0 {
+		for _, fe := range errs {
+			ve.Unchecked = append(ve.Unchecked, fe.Name)
+		}
+		return m, ve
+	}
+	if len(errs) > 0 {
+		return m, errs
+	}
+	return m, nil
+}
+
+// verifyObject checks a single object of a backup and describes what does not
+// match the manifest, or returns "missing" if the object does not exist. An
+// empty description means the object is fine.
+func verifyObject(ctx context.Context, b *blob.Bucket, obj ManifestObject, algorithm string, opts VerifyOptions) (string, error) {
+	attrs, err := b.Attributes(ctx, obj.Key)
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return "missing", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if attrs.Size != obj.Size {
+		return fmt.Sprintf("size %d, expected %d", attrs.Size, obj.Size), nil
+	}
+	if !opts.Checksum {
+		return "", nil
+	}
+	// An MD5 that does not match is not proof of corruption, e.g. S3 reports
+	// the ETag of objects encrypted with SSE-KMS, so hash the object then.
+	if !opts.NoProviderMD5 && len(attrs.MD5) > 0 && hex.EncodeToString(attrs.MD5) == obj.MD5 {
+		return "", nil
+	}
+
+	h, err := NewChecksum(algorithm)
+	if err != nil {
+		return "", err
+	}
+	r, err := b.NewReader(ctx, obj.Key, nil)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	if n != obj.Size {
+		return fmt.Sprintf("read %d bytes, expected %d", n, obj.Size), nil
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != obj.Checksum {
+		return fmt.Sprintf("checksum %s, expected %s", actual, obj.Checksum), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyUncheckedObjects(t *testing.T) {
+	ctx := context.Background()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	b := newFileBucket(t, filepath.Join(dir, "store"))
+	if err := b.WriteAll(ctx, "backup/a.sql", []byte("a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Objects cannot be hashed with an unknown checksum algorithm.
+	m := &Manifest{Name: "backup", ChecksumAlgorithm: "unknown", Objects: []ManifestObject{{Key: "backup/a.sql", Size: 1}}}
+	if err := WriteManifest(ctx, b, "backup", m); err != nil {
+		t.Fatal(err)
+	}
+	opts := VerifyOptions{Checksum: true, NoProviderMD5: true}
+	_, err := Verify(ctx, b, "backup", opts)
+	if errs, ok := err.(TransferErrors); !ok || len(errs) != 1 || errs[0].Name != "backup/a.sql" {
+		t.Fatalf("Verify = %v, want backup/a.sql unchecked", err)
+	}
+
+	// Problems found in the other objects are reported along with them.
+	m.Objects = append(m.Objects, ManifestObject{Key: "backup/b.sql", Size: 1})
+	if err = WriteManifest(ctx, b, "backup", m); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Verify(ctx, b, "backup", opts)
+	want := &VerifyError{Prefix: "backup", Missing: []string{"backup/b.sql"}, Unchecked: []string{"backup/a.sql"}}
+	if !reflect.DeepEqual(err, want) {
+		t.Fatalf("Verify = %v, want %v", err, want)
+	}
+}